@@ -0,0 +1,206 @@
+package workerpoolxt
+
+import (
+	"sync"
+	"time"
+)
+
+// AutoscaleConfig configures Autoscale
+type AutoscaleConfig struct {
+	Min      int             // Min is the fewest jobs allowed to run at once, at least 1
+	Max      int             // Max is the most jobs allowed to run at once, at least Min
+	Interval time.Duration   // Interval is how often we rescale, defaults to 1 second
+	Strategy ScalingStrategy // Strategy decides the new size, defaults to QueueDepthScaling
+	Clock    Clock           // Clock defaults to the pool Clock, see SetClock
+}
+
+// ScalingStats is what a ScalingStrategy decides the new size from.
+// Completed, Failed and AvgDuration only count jobs that finished since the last rescale.
+type ScalingStats struct {
+	Size        int           // Size is how many jobs are allowed to run at once right now
+	Min         int           // Min is AutoscaleConfig.Min
+	Max         int           // Max is AutoscaleConfig.Max
+	Waiting     int           // Waiting is how many jobs are waiting to start
+	Running     int           // Running is how many jobs are running
+	Completed   int           // Completed is how many jobs finished
+	Failed      int           // Failed is how many of the finished jobs had an error
+	AvgDuration time.Duration // AvgDuration is the average Result.Duration() of finished jobs
+}
+
+// ErrorRate returns the fraction (0-1) of finished jobs that failed
+func (s ScalingStats) ErrorRate() float64 {
+	if s.Completed == 0 {
+		return 0
+	}
+	return float64(s.Failed) / float64(s.Completed)
+}
+
+// ScalingStrategy returns the size the pool should have next.
+// Whatever it returns is kept between Min and Max for you.
+type ScalingStrategy interface {
+	Scale(ScalingStats) int
+}
+
+// ScalingStrategyFunc lets you use a func as a ScalingStrategy
+type ScalingStrategyFunc func(ScalingStats) int
+
+// Scale calls f
+func (f ScalingStrategyFunc) Scale(s ScalingStats) int {
+	return f(s)
+}
+
+// QueueDepthScaling grows by Step while jobs are waiting and shrinks by Step
+// while fewer than half of the allowed jobs are running. Step defaults to 1.
+type QueueDepthScaling struct {
+	Step int
+}
+
+// Scale returns the new size
+func (q QueueDepthScaling) Scale(s ScalingStats) int {
+	step := atLeastOne(q.Step)
+	switch {
+	case s.Waiting > 0:
+		return s.Size + step
+	case s.Running < s.Size/2:
+		return s.Size - step
+	}
+	return s.Size
+}
+
+// LatencyScaling shrinks by Step when jobs took longer than Target on average,
+// which usually means whatever they depend on is struggling. Otherwise it leaves
+// the decision to Next, which defaults to QueueDepthScaling.
+type LatencyScaling struct {
+	Target time.Duration
+	Step   int
+	Next   ScalingStrategy
+}
+
+// Scale returns the new size
+func (l LatencyScaling) Scale(s ScalingStats) int {
+	if s.Completed > 0 && s.AvgDuration > l.Target {
+		return s.Size - atLeastOne(l.Step)
+	}
+	return orQueueDepth(l.Next).Scale(s)
+}
+
+// ErrorRateScaling shrinks by Step when more than MaxErrorRate (0-1) of the jobs
+// that finished failed. Otherwise it leaves the decision to Next, which defaults
+// to QueueDepthScaling.
+type ErrorRateScaling struct {
+	MaxErrorRate float64
+	Step         int
+	Next         ScalingStrategy
+}
+
+// Scale returns the new size
+func (e ErrorRateScaling) Scale(s ScalingStats) int {
+	if s.ErrorRate() > e.MaxErrorRate {
+		return s.Size - atLeastOne(e.Step)
+	}
+	return orQueueDepth(e.Next).Scale(s)
+}
+
+// Autoscale resizes the pool every Interval, between Min and Max, using Strategy.
+// It runs until the pool is stopped and should only be called once.
+func (p *WorkerPoolXT) Autoscale(c AutoscaleConfig) {
+	c.Min = atLeastOne(c.Min)
+	if c.Max < c.Min {
+		c.Max = c.Min
+	}
+	if c.Interval <= 0 {
+		c.Interval = time.Second
+	}
+	if c.Strategy == nil {
+		c.Strategy = QueueDepthScaling{}
+	}
+	if c.Clock == nil {
+		c.Clock = p.clock
+	}
+
+	p.Resize(clamp(p.Size(), c.Min, c.Max))
+	go p.autoscale(c)
+}
+
+// autoscale rescales the pool every tick until the pool is stopped
+func (p *WorkerPoolXT) autoscale(c AutoscaleConfig) {
+	for {
+		t := c.Clock.NewTimer(c.Interval)
+		select {
+		case <-p.quit:
+			t.Stop()
+			return
+		case <-t.C():
+		}
+
+		waiting, running := p.sched.counts()
+		completed, failed, avg := p.stats.reset()
+		s := ScalingStats{
+			Size:        p.Size(),
+			Min:         c.Min,
+			Max:         c.Max,
+			Waiting:     waiting,
+			Running:     running,
+			Completed:   completed,
+			Failed:      failed,
+			AvgDuration: avg,
+		}
+		p.Resize(clamp(c.Strategy.Scale(s), c.Min, c.Max))
+	}
+}
+
+// jobStats counts jobs that finished since it was last reset
+type jobStats struct {
+	mu        sync.Mutex
+	completed int
+	failed    int
+	duration  time.Duration
+}
+
+// record counts a finished job
+func (s *jobStats) record(r Result) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.completed++
+	if r.Error != nil {
+		s.failed++
+	}
+	s.duration += r.duration
+}
+
+// reset returns what was counted so far and starts counting from 0 again
+func (s *jobStats) reset() (completed, failed int, avg time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	completed, failed = s.completed, s.failed
+	if completed > 0 {
+		avg = s.duration / time.Duration(completed)
+	}
+	s.completed, s.failed, s.duration = 0, 0, 0
+	return completed, failed, avg
+}
+
+// orQueueDepth returns s, or QueueDepthScaling if s is nil
+func orQueueDepth(s ScalingStrategy) ScalingStrategy {
+	if s == nil {
+		return QueueDepthScaling{}
+	}
+	return s
+}
+
+func atLeastOne(n int) int {
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+func clamp(n, min, max int) int {
+	if n < min {
+		return min
+	}
+	if n > max {
+		return max
+	}
+	return n
+}
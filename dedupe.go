@@ -0,0 +1,86 @@
+package workerpoolxt
+
+import (
+	"sync"
+	"time"
+)
+
+// SetDedupeWindow sets how long the Job.IdempotencyKey of a finished job is
+// remembered. Keys are only remembered while their job is queued or running
+// (d = 0) by default. It should be called before submitting jobs.
+//
+// Submitting a job whose key is remembered gives back the Future of the job the
+// key belongs to instead of running it again, so every caller gets the same
+// Result. The pool collects that Result once for every submission, so the
+// result count stays equal to the job count, and cancelling the Future cancels
+// the job for every caller. A scheduled job's key belongs to it from when it is
+// scheduled, every run of a recurring job is deduplicated on its own.
+func (p *WorkerPoolXT) SetDedupeWindow(d time.Duration) {
+	p.dedupe.mu.Lock()
+	defer p.dedupe.mu.Unlock()
+	p.dedupe.window = d
+}
+
+// dedupe remembers jobs by Job.IdempotencyKey
+type dedupe struct {
+	mu     sync.Mutex
+	window time.Duration          // window is how long keys of finished jobs are remembered
+	jobs   map[string]*dedupedJob // jobs holds the job each key belongs to
+	sweep  time.Time              // sweep is when we next forget keys whose window has passed
+}
+
+// dedupedJob is the job an idempotency key belongs to
+type dedupedJob struct {
+	future   *Future
+	finished time.Time // finished is zero while the job is queued or running
+}
+
+// claim returns the Future of the job key belongs to, or claims key for f
+// and returns f if key does not belong to a job (anymore)
+func (d *dedupe) claim(key string, f *Future, now time.Time) (owner *Future) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.jobs == nil {
+		d.jobs = make(map[string]*dedupedJob)
+	}
+	if d.window > 0 && now.After(d.sweep) {
+		for k, j := range d.jobs {
+			if d.expired(j, now) {
+				delete(d.jobs, k)
+			}
+		}
+		d.sweep = now.Add(d.window)
+	}
+
+	if j, ok := d.jobs[key]; ok && !d.expired(j, now) {
+		return j.future
+	}
+	d.jobs[key] = &dedupedJob{future: f}
+	return f
+}
+
+// finish starts the window of the job key belongs to, if that is still f
+func (d *dedupe) finish(key string, f *Future, now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if j, ok := d.jobs[key]; ok && j.future == f {
+		j.finished = now
+		if d.window <= 0 {
+			delete(d.jobs, key)
+		}
+	}
+}
+
+// release gives up key if it still belongs to f, used when f's job was not accepted
+func (d *dedupe) release(key string, f *Future) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if j, ok := d.jobs[key]; ok && j.future == f {
+		delete(d.jobs, key)
+	}
+}
+
+// expired reports if a job finished more than window ago. Must hold d.mu.
+func (d *dedupe) expired(j *dedupedJob, now time.Time) bool {
+	return !j.finished.IsZero() && now.Sub(j.finished) >= d.window
+}
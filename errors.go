@@ -0,0 +1,92 @@
+package workerpoolxt
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cenkalti/backoff"
+)
+
+// ErrJobNotRun is the error of a job that was submitted but never ran
+// because the pool was stopped first
+var ErrJobNotRun = errors.New("workerpoolxt: job not run")
+
+// ErrCancelledAtShutdown is the error of a job that was still running when
+// the context passed to Shutdown was done
+var ErrCancelledAtShutdown = errors.New("workerpoolxt: job cancelled at shutdown")
+
+// ErrPoolStopped means a job was not accepted because the pool is stopping
+var ErrPoolStopped = errors.New("workerpoolxt: pool is stopped")
+
+// ErrAttemptTimeout is the error of an attempt that ran longer than Job.AttemptTimeout
+var ErrAttemptTimeout = errors.New("workerpoolxt: attempt timed out")
+
+var (
+	// ErrQueueFull means a job was not accepted because the queue was full, see SetMaxQueue
+	ErrQueueFull = errors.New("workerpoolxt: queue is full")
+	// ErrJobDropped is the error of a job that was accepted but dropped to make room in a full queue
+	ErrJobDropped = errors.New("workerpoolxt: job dropped from full queue")
+)
+
+var (
+	// ErrWorkflowCycle means steps of a Workflow depend on each other in a loop
+	ErrWorkflowCycle = errors.New("workerpoolxt: workflow has a cycle")
+	// ErrUnknownDependency means a Workflow step depends on a step that does not exist
+	ErrUnknownDependency = errors.New("workerpoolxt: workflow step depends on unknown step")
+	// ErrDuplicateStep means two Workflow steps have the same ID
+	ErrDuplicateStep = errors.New("workerpoolxt: workflow has duplicate step")
+	// ErrUpstreamFailed is the error of a Workflow step skipped because a step it depends on failed
+	ErrUpstreamFailed = errors.New("workerpoolxt: upstream step failed")
+	// ErrWorkflowFailed is the error of a Workflow step that never started because another step failed
+	ErrWorkflowFailed = errors.New("workerpoolxt: workflow failed")
+)
+
+// PanicError is the Result.Error of a job whose task panicked
+type PanicError struct {
+	Value interface{} // Value is whatever the task panicked with
+	Stack []byte      // Stack is the stack trace of the panicking goroutine
+}
+
+// Error returns the panic value as an error string
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("task panicked: %v", e.Value)
+}
+
+// PermanentError signals that a job should not be retried.
+// The wrapped error is what ends up in Result.Error.
+type PermanentError struct {
+	Err error
+}
+
+// Permanent wraps err so that the job returning it is not retried
+func Permanent(err error) error {
+	return &PermanentError{Err: err}
+}
+
+// Error returns the wrapped error string
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the wrapped error
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// isPermanent reports if err was wrapped with Permanent (ours or `backoff`'s)
+func isPermanent(err error) bool {
+	var pe *PermanentError
+	var bpe *backoff.PermanentError
+	return errors.As(err, &pe) || errors.As(err, &bpe)
+}
+
+// unwrapPermanent removes the Permanent wrapper from err, if there is one
+func unwrapPermanent(err error) error {
+	switch e := err.(type) {
+	case *PermanentError:
+		return e.Err
+	case *backoff.PermanentError:
+		return e.Err
+	}
+	return err
+}
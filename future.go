@@ -0,0 +1,170 @@
+package workerpoolxt
+
+import (
+	"context"
+	"sync"
+)
+
+// Status describes where a job is in its lifecycle
+type Status int
+
+const (
+	// StatusPending means the job is waiting for a worker
+	StatusPending Status = iota
+	// StatusRunning means the job has been picked up by a worker
+	StatusRunning
+	// StatusSucceeded means the job finished without an error
+	StatusSucceeded
+	// StatusFailed means the job finished with an error
+	StatusFailed
+	// StatusCancelled means the job was cancelled via Future.Cancel
+	StatusCancelled
+)
+
+// String returns a human readable status
+func (s Status) String() string {
+	switch s {
+	case StatusPending:
+		return "pending"
+	case StatusRunning:
+		return "running"
+	case StatusSucceeded:
+		return "succeeded"
+	case StatusFailed:
+		return "failed"
+	case StatusCancelled:
+		return "cancelled"
+	default:
+		return "unknown"
+	}
+}
+
+// Future is a handle to a submitted job which lets you wait on,
+// cancel, or inspect that specific job
+type Future struct {
+	mu        sync.Mutex
+	name      string
+	status    Status
+	cancelled bool
+	cancel    context.CancelFunc // cancel is the job's childCtx cancelFunc, set once the job starts
+	done      chan struct{}
+	result    Result
+	shared    int    // shared is how many more submissions share this Future, see Job.IdempotencyKey
+	completed func() // completed is called once the result is stored
+}
+
+// newFuture creates a pending Future
+func newFuture(name string) *Future {
+	return &Future{
+		name: name,
+		done: make(chan struct{}),
+	}
+}
+
+// Name returns the name of the job this Future belongs to
+func (f *Future) Name() string {
+	return f.name
+}
+
+// Done returns a chan that is closed once the job result is available
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait blocks until the job result is available or ctx is done,
+// whichever happens first
+func (f *Future) Wait(ctx context.Context) (Result, error) {
+	select {
+	case <-f.done:
+		return f.result, nil
+	case <-ctx.Done():
+		return Result{}, ctx.Err()
+	}
+}
+
+// Cancel cancels the job. A job that has not started yet will not run, it is
+// taken out of the queue and its Result has context.Canceled. A running job has
+// its context cancelled. Cancelling a finished job is a no-op.
+func (f *Future) Cancel() {
+	f.mu.Lock()
+	if f.cancelled || f.status > StatusRunning {
+		f.mu.Unlock()
+		return
+	}
+	f.cancelled = true
+	cancel := f.cancel
+	f.mu.Unlock()
+	// Outside the lock, cancelling a job that has not started completes the Future
+	if cancel != nil {
+		cancel()
+	}
+}
+
+// Status returns the current status of the job
+func (f *Future) Status() Status {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.status
+}
+
+// onCancel sets what Cancel does until the job starts
+func (f *Future) onCancel(cancel func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cancel = cancel
+}
+
+// onComplete sets a func to call once the result is stored
+func (f *Future) onComplete(completed func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.completed = completed
+}
+
+// start marks the job as running and stores its cancelFunc.
+// Returns false if the job was cancelled before it started.
+func (f *Future) start(cancel context.CancelFunc) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cancel = cancel
+	if f.cancelled {
+		cancel()
+		return false
+	}
+	f.status = StatusRunning
+	return true
+}
+
+// share counts one more submission sharing the Future, so the Result is sent for it too.
+// If the Result has been sent already it returns it and done is true, send it yourself.
+func (f *Future) share() (r Result, done bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.status > StatusRunning {
+		return f.result, true
+	}
+	f.shared++
+	return Result{}, false
+}
+
+// complete stores the job result and releases anyone waiting on it. It returns
+// how many more submissions share the Future, the Result is sent for each of them too.
+func (f *Future) complete(r Result) (shared int) {
+	f.mu.Lock()
+	switch {
+	case r.Error == nil:
+		f.status = StatusSucceeded
+	case f.cancelled:
+		f.status = StatusCancelled
+	default:
+		f.status = StatusFailed
+	}
+	f.result = r
+	close(f.done)
+	completed, shared := f.completed, f.shared
+	f.mu.Unlock()
+	if completed != nil {
+		completed()
+	}
+	return shared
+}
@@ -1,6 +1,6 @@
 module github.com/oze4/workerpoolxt
 
-go 1.15
+go 1.18
 
 require (
 	github.com/cenkalti/backoff v2.2.1+incompatible
@@ -9,3 +9,5 @@ require (
 	k8s.io/apimachinery v0.17.0
 	k8s.io/client-go v0.17.0
 )
+
+require github.com/gammazero/deque v0.0.0-20200721202602-07291166fe33 // indirect
@@ -2,6 +2,7 @@ package workerpoolxt
 
 import (
 	"context"
+	"runtime/debug"
 	"time"
 
 	"github.com/cenkalti/backoff"
@@ -9,32 +10,50 @@ import (
 
 // Job holds job data
 type Job struct {
-	Name      string
-	Task      func(Options) Result
-	Context   context.Context
-	Options   Options
-	Retry     int
-	childCtx  context.Context    // childCtx is "child" context of Job.Context, lets us "catch" parent Context.Err()
-	done      context.CancelFunc // done is the cancelFunc for childCtx
-	result    chan Result        // result is the chan we send job reslts on
-	startedAt time.Time          // startedAt is the time at which the job started
+	Name           string
+	Task           func(Options) Result
+	TaskCtx        func(context.Context, Options) Result // TaskCtx is used instead of Task when set, it gets the job (or attempt) context so it can stop when cancelled
+	Context        context.Context
+	Options        Options
+	Priority       int       // Priority decides which waiting job starts next, higher first, see PriorityLow/Normal/High
+	RateKey        string    // RateKey picks the rate limit set with SetKeyRateLimit for this job
+	Resources      Resources // Resources is how much of each resource the job uses while running, see SetCapacity
+	Queue          string    // Queue is the name of the queue the job waits in, see SetQueue
+	ConcurrencyKey string    // ConcurrencyKey limits how many jobs with the same key run at once, see SetKeyConcurrency
+	IdempotencyKey string    // IdempotencyKey makes submitting a job with the key of a queued, running or recently finished job share that job instead, see SetDedupeWindow
+	Retry          int
+	AttemptTimeout time.Duration      // AttemptTimeout bounds each attempt on its own, a hung attempt is abandoned and retried while Context still bounds the whole job
+	RetryIf        func(error) bool   // RetryIf reports whether an error should be retried, defaults to the pool RetryIf, nil retries every error
+	RetryPolicy    RetryPolicy        // RetryPolicy decides how long to wait between retries, defaults to the pool RetryPolicy
+	RetryPanics    bool               // RetryPanics retries a job whose task panicked, panics are not retried by default
+	childCtx       context.Context    // childCtx is "child" context of Job.Context, lets us "catch" parent Context.Err()
+	done           context.CancelFunc // done is the cancelFunc for childCtx
+	result         chan Result        // result is the chan we send job reslts on
+	startedAt      time.Time          // startedAt is the time at which the job started
+	future         *Future            // future is the handle returned to whoever submitted the job
+	history        *attemptHistory    // history records every attempt at running the job
+	seq            uint64             // seq is the order the job was submitted in
+	rank           int64              // rank orders waiting jobs, lowest starts first
+	gone           chan struct{}      // gone is closed when a job the scheduler watches leaves the line
 }
 
+// Priority classes for Job.Priority, any int works
+const (
+	PriorityLow    = -10
+	PriorityNormal = 0
+	PriorityHigh   = 10
+)
+
 // Options hold misc options
 type Options map[string]interface{}
 
-// payload is a `func() error`
-type payload func() error
-
-func (p payload) toBackOffOperation() backoff.Operation {
-	return (backoff.Operation)(p)
-}
-
 // errResult returns a new result based upon your error
 func (j *Job) errResult(err error) Result {
 	return Result{
 		Error:    err,
+		name:     j.Name,
 		duration: time.Since(j.startedAt),
+		attempts: j.history.list(),
 	}
 }
 
@@ -52,29 +71,68 @@ func (j *Job) getResult() Result {
 	}
 }
 
-// run calls Job.Task using provided variables accordingly
+// run calls Job.Task, retrying failed attempts according to Job.Retry,
+// Job.RetryPolicy and Job.RetryIf, then sends the final result
 func (j *Job) run() {
-	payload := j.toPayload()
-
-	// Job not using retry, just call the payload, no special handling needed
-	f := func() {
-		payload()
-	}
+	var b backoff.BackOff
+	var wait *time.Timer
+
+	for attempt := 0; ; attempt++ {
+		r := j.attemptResult(attempt + 1)
+		err := r.Error
+		r.Error = unwrapPermanent(err)
+
+		// Job not using retry or it succeeded, no special handling needed
+		if err == nil || j.Retry == 0 {
+			j.result <- r
+			return
+		}
 
-	// Job using retry, wrap our payload with backoff before calling
-	if j.Retry > 0 {
-		b := backoff.WithMaxRetries(backoff.NewExponentialBackOff(), uint64(j.Retry))
-		f = func() {
-			err := backoff.Retry(payload.toBackOffOperation(), b)
-			if err != nil {
-				// Since our payload will be sending the success result (if there is one)
-				// we only need to handle job errors that backoff gives us
-				j.result <- j.errResult(err)
+		r.retryStop = j.retryStopReason(err, attempt)
+		if r.retryStop == RetryNotStopped {
+			if b == nil {
+				b = j.backOff()
 			}
+			next := b.NextBackOff()
+			if next == backoff.Stop {
+				r.retryStop = RetryPolicyStopped
+			} else if wait == nil {
+				wait = time.NewTimer(next)
+				defer wait.Stop()
+			} else {
+				wait.Reset(next)
+			}
+		}
+		if r.retryStop != RetryNotStopped {
+			j.result <- r
+			return
+		}
+
+		select {
+		case <-wait.C:
+		case <-j.childCtx.Done():
+			r.retryStop = RetryContextDone
+			j.result <- r
+			return
 		}
 	}
+}
 
-	f()
+// retryStopReason tells us if a failed attempt should not be retried, and why
+func (j *Job) retryStopReason(err error, attempt int) RetryStopReason {
+	if _, ok := err.(*PanicError); ok {
+		if !j.RetryPanics {
+			return RetryPanicked
+		}
+	} else if isPermanent(err) {
+		return RetryPermanent
+	} else if j.RetryIf != nil && !j.RetryIf(err) {
+		return RetryNotRetryable
+	}
+	if attempt >= j.Retry {
+		return RetryExhausted
+	}
+	return RetryNotStopped
 }
 
 // runDone runs the job and calls done (which is a context.cancelFunc)
@@ -83,23 +141,65 @@ func (j *Job) runDone() {
 	j.done()
 }
 
-// toPayload converts our job into the correct type so we can use package `backoff` for retry purposes
-func (j *Job) toPayload() payload {
-	// Our payload is crafted differently if a Job is using Retry
-	return func() error {
-		r := j.Task(j.Options)
-		r.duration = time.Since(j.startedAt)
-		r.name = j.Name
-
-		// Only return error if job is using retry
-		if r.Error != nil && j.Retry > 0 {
-			return r.Error
+// callTask calls Job.TaskCtx or Job.Task, turning a panic into a Result
+func (j *Job) callTask(ctx context.Context) (r Result) {
+	// recover() returns nil for panic(nil) before Go 1.21, so don't rely on it
+	panicked := true
+	defer func() {
+		if v := recover(); panicked {
+			r = Result{Error: &PanicError{Value: v, Stack: debug.Stack()}}
+		}
+	}()
+	if j.TaskCtx != nil {
+		r = j.TaskCtx(ctx, j.Options)
+	} else {
+		r = j.Task(j.Options)
+	}
+	panicked = false
+	return r
+}
+
+// callAttempt calls the task, giving up on it once Job.AttemptTimeout has passed
+func (j *Job) callAttempt() Result {
+	if j.AttemptTimeout <= 0 {
+		return j.callTask(j.childCtx)
+	}
+
+	ctx, cancel := context.WithTimeout(j.childCtx, j.AttemptTimeout)
+	defer cancel()
+
+	// Buffered so an abandoned attempt can still finish and exit
+	result := make(chan Result, 1)
+	go func() {
+		result <- j.callTask(ctx)
+	}()
+
+	select {
+	case r := <-result:
+		return r
+	case <-ctx.Done():
+		if err := j.childCtx.Err(); err != nil {
+			return Result{Error: err}
 		}
+		return Result{Error: ErrAttemptTimeout}
+	}
+}
 
-		// Send our result to our result chan
-		j.result <- r
+// attemptResult makes a single attempt at running the job
+func (j *Job) attemptResult(number int) Result {
+	i := j.history.start(number)
+	r := j.callAttempt()
+	j.history.finish(i, unwrapPermanent(r.Error))
+	r.duration = time.Since(j.startedAt)
+	r.name = j.Name
+	r.attempts = j.history.list()
+	return r
+}
 
-		// Unlike returning an error it does not matter if we return nil here
-		return nil
+// backOff returns a new backoff from the job RetryPolicy, exponential by default
+func (j *Job) backOff() backoff.BackOff {
+	if j.RetryPolicy == nil {
+		return ExponentialRetry{}.BackOff()
 	}
+	return j.RetryPolicy.BackOff()
 }
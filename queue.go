@@ -0,0 +1,59 @@
+package workerpoolxt
+
+// QueuePolicy decides what happens when a job is submitted while the queue is full
+type QueuePolicy int
+
+const (
+	// QueueBlock makes submitting wait until there is room. TrySubmitXT returns
+	// ErrQueueFull instead and SubmitXTCtx gives up when its context is done.
+	QueueBlock QueuePolicy = iota
+	// QueueReject does not accept the job, TrySubmitXT and SubmitXTCtx return
+	// ErrQueueFull, SubmitXT gives the job a Result with ErrQueueFull.
+	QueueReject
+	// QueueDropOldest accepts the job and drops the job that has been waiting
+	// longest, whatever its priority. The dropped job gets a Result with ErrJobDropped.
+	QueueDropOldest
+	// QueueDropNewest drops the job being submitted, it gets a Result with ErrJobDropped.
+	// TrySubmitXT and SubmitXTCtx return ErrQueueFull instead.
+	QueueDropNewest
+)
+
+// SetMaxQueue limits how many jobs submitted with SubmitXT may wait to start,
+// policy decides what happens to jobs submitted while that many are waiting.
+// There is no limit (n = 0) by default. Jobs dropped or rejected by SubmitXT
+// still have a Result, so the result count stays equal to the job count.
+//
+// With QueueBlock, don't submit from inside a task: once the queue is full
+// every worker could end up waiting for room that only they can make.
+func (p *WorkerPoolXT) SetMaxQueue(n int, policy QueuePolicy) {
+	p.sched.setMaxQueue(n, policy)
+}
+
+// QueueConfig configures a named queue, see SetQueue
+type QueueConfig struct {
+	Weight     int // Weight is how many jobs the queue starts for every job a queue with weight 1 starts, defaults to 1
+	MaxRunning int // MaxRunning is how many of the queue's jobs may run at once, 0 means no limit besides the pool
+}
+
+// QueueStats are the stats of a named queue
+type QueueStats struct {
+	Waiting   int // Waiting is how many jobs are waiting to start
+	Running   int // Running is how many jobs are running
+	Completed int // Completed is how many jobs finished
+	Failed    int // Failed is how many of the finished jobs had an error
+}
+
+// SetQueue configures the queue jobs with Job.Queue name wait in. Every queue
+// with jobs waiting gets turns at starting one, in proportion to its weight, so
+// one busy queue can't starve the others. Priority only orders jobs within a
+// queue. Queues that were never configured have a weight of 1 and no limit,
+// jobs without a Queue are in the queue named "". It is safe to call while
+// jobs are running.
+func (p *WorkerPoolXT) SetQueue(name string, c QueueConfig) {
+	p.sched.setQueue(name, c.Weight, c.MaxRunning)
+}
+
+// QueueStats returns the stats of every queue that has had jobs or was configured, by name
+func (p *WorkerPoolXT) QueueStats() map[string]QueueStats {
+	return p.sched.queueStats()
+}
@@ -0,0 +1,81 @@
+package workerpoolxt
+
+import (
+	"math"
+	"time"
+)
+
+// RateLimit caps how often jobs may start, PerSecond on average with bursts of up
+// to Burst jobs (at least 1). It is a token bucket: it starts full, every job that
+// starts takes a token and tokens are added back at PerSecond.
+type RateLimit struct {
+	PerSecond float64
+	Burst     int
+}
+
+// SetRateLimit caps how often any job submitted with SubmitXT may start.
+// A job waiting for its turn doesn't hold a worker, and gives up once its
+// Context is done. It should be called before submitting jobs.
+func (p *WorkerPoolXT) SetRateLimit(l RateLimit) {
+	p.sched.setRate(l)
+}
+
+// SetKeyRateLimit caps how often jobs with Job.RateKey key may start, on top
+// of the pool rate limit. Jobs with a key that has no rate limit are only
+// limited by the pool, and jobs waiting for a key don't hold back other jobs.
+// It should be called before submitting jobs.
+func (p *WorkerPoolXT) SetKeyRateLimit(key string, l RateLimit) {
+	p.sched.setKeyRate(key, l)
+}
+
+// rateLimit is a RateLimit along with the jobs waiting for its tokens
+type rateLimit struct {
+	bucket *tokenBucket
+	parked []*Job // parked holds jobs with its RateKey that wait for a token, key rate limits only
+	armed  bool   // armed means a timer wakes the scheduler once there is a token
+}
+
+// tokenBucket is a RateLimit, it is only used while holding scheduler.mu
+type tokenBucket struct {
+	rate   float64 // rate is how many tokens are added per second
+	burst  float64 // burst is how many tokens the bucket holds
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(l RateLimit, now time.Time) *tokenBucket {
+	burst := float64(atLeastOne(l.Burst))
+	return &tokenBucket{
+		rate:   l.PerSecond,
+		burst:  burst,
+		tokens: burst,
+		last:   now,
+	}
+}
+
+// due returns how long until there is a token, 0 if there is one now
+func (b *tokenBucket) due(now time.Time) time.Duration {
+	if b.rate <= 0 {
+		return 0
+	}
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+	// Allow for rounding, a token added over exactly 1/rate is still a token
+	missing := 1 - b.tokens
+	if missing <= 1e-9 {
+		return 0
+	}
+	return time.Duration(math.Ceil(missing / b.rate * float64(time.Second)))
+}
+
+// take takes a token, due must have returned 0
+func (b *tokenBucket) take() {
+	if b.rate > 0 {
+		b.tokens--
+	}
+}
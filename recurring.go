@@ -0,0 +1,107 @@
+package workerpoolxt
+
+import (
+	"sync"
+)
+
+// RecurringJob is a Job that runs every time its Schedule says so
+type RecurringJob struct {
+	Job          Job
+	Schedule     Schedule // Schedule is Every(d), ParseCron(expr) or your own
+	AllowOverlap bool     // AllowOverlap starts a run even if the previous one has not finished, by default that run is skipped
+}
+
+// Recurrence controls a RecurringJob submitted with SubmitRecurring
+type Recurrence struct {
+	mu      sync.Mutex
+	runs    int
+	skipped int
+	once    sync.Once
+	stopped chan struct{}
+}
+
+// Stop stops submitting runs, runs already submitted still finish.
+// It is safe to call more than once.
+func (r *Recurrence) Stop() {
+	r.once.Do(func() { close(r.stopped) })
+}
+
+// Runs returns how many runs were submitted
+func (r *Recurrence) Runs() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.runs
+}
+
+// Skipped returns how many runs were skipped because the previous run had not finished
+func (r *Recurrence) Skipped() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.skipped
+}
+
+// SubmitRecurring submits rj.Job every time rj.Schedule comes due, until the
+// Recurrence is stopped, the Schedule runs out or the pool is stopped. Every
+// run is a regular job, so its Result is collected by the pool (or streamed)
+// like any other. Times come from the pool Clock, see SetClock.
+func (p *WorkerPoolXT) SubmitRecurring(rj RecurringJob) *Recurrence {
+	rec := &Recurrence{stopped: make(chan struct{})}
+	if rj.Schedule == nil || !p.enter() {
+		rec.Stop()
+		return rec
+	}
+
+	go p.recur(rec, rj)
+	return rec
+}
+
+// recur submits a run every time the schedule comes due
+func (p *WorkerPoolXT) recur(rec *Recurrence, rj RecurringJob) {
+	defer p.delayed.Done()
+	var last *Future
+	for {
+		now := p.clock.Now()
+		next := rj.Schedule.Next(now)
+		if next.IsZero() {
+			return
+		}
+
+		t := p.clock.NewTimer(next.Sub(now))
+		select {
+		case <-t.C():
+		case <-rec.stopped:
+			t.Stop()
+			return
+		case <-p.quit:
+			t.Stop()
+			return
+		}
+
+		if !rj.AllowOverlap && last != nil && !isDone(last) {
+			rec.mu.Lock()
+			rec.skipped++
+			rec.mu.Unlock()
+			continue
+		}
+
+		j := rj.Job
+		j.future = newFuture(j.Name)
+		if p.claim(&j) {
+			p.submitDue(&j)
+		}
+		last = j.future
+		rec.mu.Lock()
+		rec.runs++
+		rec.mu.Unlock()
+	}
+}
+
+// isDone reports if a Future has its Result
+func isDone(f *Future) bool {
+	select {
+	case <-f.Done():
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,17 @@
+package workerpoolxt
+
+// Resources are amounts of named resources, like {"cpu": 2, "memMB": 512}.
+// A job that should count as more than one job uses a single resource as its weight.
+type Resources map[string]int
+
+// SetCapacity limits how much of each resource the jobs running at once may use.
+// Jobs still start in the order they would otherwise, so a job waiting for
+// resources to free up holds back the jobs behind it rather than being starved
+// by smaller ones. Resources without a capacity are not limited, and a job that
+// needs more than a capacity starts once no running job uses that resource.
+//
+// The number of jobs running at once is still limited by the pool size.
+// It is safe to call while jobs are running.
+func (p *WorkerPoolXT) SetCapacity(c Resources) {
+	p.sched.setCapacity(c)
+}
@@ -1,15 +1,18 @@
 package workerpoolxt
 
 import (
+	"sync"
 	"time"
 )
 
 // Result holds job results
 type Result struct {
-	Error    error
-	Data     interface{}
-	name     string
-	duration time.Duration
+	Error     error
+	Data      interface{}
+	name      string
+	duration  time.Duration
+	retryStop RetryStopReason
+	attempts  []Attempt
 }
 
 // Duration returns the amount of time it took to run the job
@@ -21,3 +24,101 @@ func (r *Result) Duration() time.Duration {
 func (r *Result) Name() string {
 	return r.name
 }
+
+// Attempts returns every attempt made at running the job, in order
+func (r *Result) Attempts() []Attempt {
+	return r.attempts
+}
+
+// RetryStopReason returns why the job stopped retrying
+func (r *Result) RetryStopReason() RetryStopReason {
+	return r.retryStop
+}
+
+// RetryStopReason describes why a job that uses Retry stopped retrying
+type RetryStopReason int
+
+const (
+	// RetryNotStopped means the job succeeded or does not use Retry
+	RetryNotStopped RetryStopReason = iota
+	// RetryExhausted means every retry was used
+	RetryExhausted
+	// RetryPermanent means the task returned an error wrapped with Permanent
+	RetryPermanent
+	// RetryNotRetryable means RetryIf reported the error as not retryable
+	RetryNotRetryable
+	// RetryPanicked means the task panicked and the job does not use RetryPanics
+	RetryPanicked
+	// RetryContextDone means the job context was done while waiting to retry
+	RetryContextDone
+	// RetryPolicyStopped means the RetryPolicy gave up, e.g. max elapsed time was reached
+	RetryPolicyStopped
+)
+
+// String returns a human readable reason
+func (r RetryStopReason) String() string {
+	switch r {
+	case RetryNotStopped:
+		return "not stopped"
+	case RetryExhausted:
+		return "retries exhausted"
+	case RetryPermanent:
+		return "permanent error"
+	case RetryNotRetryable:
+		return "error not retryable"
+	case RetryPanicked:
+		return "task panicked"
+	case RetryContextDone:
+		return "context done"
+	case RetryPolicyStopped:
+		return "retry policy stopped"
+	default:
+		return "unknown"
+	}
+}
+
+// Attempt describes a single attempt at running a job
+type Attempt struct {
+	Number int       // Number starts at 1 for the first attempt
+	Start  time.Time // Start is when the attempt started
+	End    time.Time // End is zero if the attempt was still running when the job gave up on it
+	Error  error     // Error is the error the attempt returned, if any
+}
+
+// Duration returns how long the attempt took, or 0 if it never finished
+func (a Attempt) Duration() time.Duration {
+	if a.End.IsZero() {
+		return 0
+	}
+	return a.End.Sub(a.Start)
+}
+
+// attemptHistory records attempts of a job. It is shared by the goroutine
+// running the task and the worker, which may give up on the task early.
+type attemptHistory struct {
+	mu       sync.Mutex
+	attempts []Attempt
+}
+
+// start records the start of an attempt and returns its index
+func (h *attemptHistory) start(number int) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.attempts = append(h.attempts, Attempt{Number: number, Start: time.Now()})
+	return len(h.attempts) - 1
+}
+
+// finish records the end of the attempt at index i
+func (h *attemptHistory) finish(i int, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.attempts[i].End = time.Now()
+	h.attempts[i].Error = err
+}
+
+// list returns a copy of every attempt so far
+func (h *attemptHistory) list() []Attempt {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]Attempt(nil), h.attempts...)
+}
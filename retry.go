@@ -0,0 +1,144 @@
+package workerpoolxt
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/cenkalti/backoff"
+)
+
+// RetryPolicy decides how long to wait between retries of a job.
+// A policy can be shared by many jobs, so it hands out a new BackOff
+// every time a job starts.
+type RetryPolicy interface {
+	BackOff() backoff.BackOff
+}
+
+// RetryPolicyFunc lets you use any backoff.BackOff as a RetryPolicy
+type RetryPolicyFunc func() backoff.BackOff
+
+// BackOff calls f
+func (f RetryPolicyFunc) BackOff() backoff.BackOff {
+	return f()
+}
+
+// ConstantRetry waits the same Interval before every retry
+type ConstantRetry struct {
+	Interval time.Duration
+}
+
+// BackOff returns a constant backoff
+func (c ConstantRetry) BackOff() backoff.BackOff {
+	return backoff.NewConstantBackOff(c.Interval)
+}
+
+// LinearRetry waits Initial before the first retry and Increment longer
+// before every retry after that, up to Max (if Max is set)
+type LinearRetry struct {
+	Initial   time.Duration
+	Increment time.Duration
+	Max       time.Duration
+}
+
+// BackOff returns a linear backoff
+func (l LinearRetry) BackOff() backoff.BackOff {
+	return &linearBackOff{policy: l}
+}
+
+// ExponentialRetry multiplies the wait by Multiplier after every retry.
+// Any field left as the zero value uses the `backoff` package default,
+// which is also what jobs use when no RetryPolicy is set.
+type ExponentialRetry struct {
+	InitialInterval     time.Duration
+	MaxInterval         time.Duration
+	Multiplier          float64
+	RandomizationFactor float64       // RandomizationFactor is the amount of jitter, 0.5 means +/- 50%, negative means no jitter
+	MaxElapsedTime      time.Duration // MaxElapsedTime is when to give up retrying, negative means never
+}
+
+// BackOff returns an exponential backoff
+func (e ExponentialRetry) BackOff() backoff.BackOff {
+	b := backoff.NewExponentialBackOff()
+	if e.InitialInterval > 0 {
+		b.InitialInterval = e.InitialInterval
+	}
+	if e.MaxInterval > 0 {
+		b.MaxInterval = e.MaxInterval
+	}
+	if e.Multiplier > 0 {
+		b.Multiplier = e.Multiplier
+	}
+	if e.RandomizationFactor > 0 {
+		b.RandomizationFactor = e.RandomizationFactor
+	} else if e.RandomizationFactor < 0 {
+		b.RandomizationFactor = 0
+	}
+	if e.MaxElapsedTime > 0 {
+		b.MaxElapsedTime = e.MaxElapsedTime
+	} else if e.MaxElapsedTime < 0 {
+		// The backoff package never stops when it is 0
+		b.MaxElapsedTime = 0
+	}
+	b.Reset()
+	return b
+}
+
+// DecorrelatedJitterRetry waits a random duration between Base and three times
+// the previous wait, capped at Max. Spreads out retries from many jobs that
+// failed at the same time.
+type DecorrelatedJitterRetry struct {
+	Base time.Duration // Base is the shortest wait, defaults to the `backoff` package initial interval
+	Max  time.Duration
+}
+
+// BackOff returns a decorrelated jitter backoff
+func (d DecorrelatedJitterRetry) BackOff() backoff.BackOff {
+	if d.Base <= 0 {
+		// Without a Base every wait would be 0
+		d.Base = backoff.DefaultInitialInterval
+	}
+	b := &decorrelatedJitterBackOff{policy: d}
+	b.Reset()
+	return b
+}
+
+// linearBackOff implements backoff.BackOff for LinearRetry
+type linearBackOff struct {
+	policy  LinearRetry
+	retries int
+}
+
+func (b *linearBackOff) Reset() {
+	b.retries = 0
+}
+
+func (b *linearBackOff) NextBackOff() time.Duration {
+	next := b.policy.Initial + time.Duration(b.retries)*b.policy.Increment
+	b.retries++
+	if b.policy.Max > 0 && next > b.policy.Max {
+		return b.policy.Max
+	}
+	return next
+}
+
+// decorrelatedJitterBackOff implements backoff.BackOff for DecorrelatedJitterRetry
+type decorrelatedJitterBackOff struct {
+	policy DecorrelatedJitterRetry
+	sleep  time.Duration
+}
+
+func (b *decorrelatedJitterBackOff) Reset() {
+	b.sleep = b.policy.Base
+}
+
+func (b *decorrelatedJitterBackOff) NextBackOff() time.Duration {
+	next := b.policy.Base
+	if upper := b.sleep*3 - b.policy.Base; upper > 0 {
+		next += time.Duration(rand.Int63n(int64(upper) + 1))
+	}
+	if b.policy.Max > 0 && next > b.policy.Max {
+		next = b.policy.Max
+	}
+	b.sleep = next
+	return next
+}
@@ -0,0 +1,835 @@
+package workerpoolxt
+
+import (
+	"container/heap"
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// scheduler holds jobs submitted with SubmitXT until they are allowed to start.
+// Each named queue is a line of jobs, higher Job.Priority first, and queues take
+// turns in proportion to their weight.
+//
+// Every job that makes the line longer also submits a dispatch func to the
+// underlying WorkerPool (a job that takes the place of a dropped one doesn't), so
+// there are always at least as many dispatch funcs queued or running as there are
+// jobs waiting. Whichever worker runs a dispatch func takes the next job that may
+// start and runs it. If no job may start (e.g. we are paused) the dispatch func
+// gives its worker back to tasks submitted with Submit, and we queue a new one
+// once a job may start. When the pool is resized beyond the number of WorkerPool
+// workers, extra runners (goroutines of our own) take jobs as well.
+//
+// Rate limits are gated here too, so jobs waiting for a token don't hold workers.
+// A job whose RateKey has no token is parked until it does, and when the pool
+// rate limit has no token no job may start. Either way a timer wakes us once
+// there is a token.
+type scheduler struct {
+	mu       sync.Mutex
+	cond     *sync.Cond            // cond is signalled when a job finishes or leaves the line, see settle
+	queues   map[string]*queue     // queues holds jobs that have not started yet, by Job.Queue
+	waiting  int                   // waiting is how many jobs have not started yet
+	vtime    float64               // vtime is the pass of the queue we last took a job from
+	keys     map[string]*key       // keys tracks jobs by Job.ConcurrencyKey
+	perKey   int                   // perKey is how many jobs with the same ConcurrencyKey may run at once
+	seq      uint64                // seq numbers jobs in the order they were submitted
+	aging    time.Duration         // aging is how long a job waits to gain 1 priority, 0 means never
+	clock    Clock                 // clock tells how long jobs have waited, for aging, and when rate limits have tokens
+	workers  int                   // workers is the size of the underlying WorkerPool
+	limit    int                   // limit is how many jobs may run at once
+	running  int                   // running is how many jobs are running
+	extra    int                   // extra is how many extra runners are running
+	idle     int                   // idle is how many jobs waiting have no dispatch func, theirs found no job that could start
+	stopped  bool                  // stopped means no more jobs may start
+	done     chan struct{}         // done is closed once stopped, rate limit timers give up
+	paused   bool                  // paused means no jobs may start until resumed
+	cap      Resources             // cap is how much of each resource running jobs may use in total
+	used     Resources             // used is how much of each resource running jobs use
+	maxQ     int                   // maxQ is how many jobs may wait, 0 means no limit
+	policy   QueuePolicy           // policy decides what happens when maxQ jobs are waiting
+	space    chan struct{}         // space is closed when a job leaves the line, if anyone is blocked on a full queue
+	rate     *rateLimit            // rate is the pool rate limit, nil means none
+	rates    map[string]*rateLimit // rates holds rate limits by Job.RateKey
+	runners  sync.WaitGroup        // runners lets us wait for extra runners to exit
+	run      func(*Job)            // run runs a job
+	dispatch func()                // dispatch queues a dispatch func on the WorkerPool
+	expire   func(*Job)            // expire takes a job whose Context is done while it waits for a token out of the line
+}
+
+// newScheduler creates a scheduler for a WorkerPool with n workers
+func newScheduler(n int, run func(*Job), dispatch func(), expire func(*Job)) *scheduler {
+	if n < 1 {
+		n = 1
+	}
+	s := &scheduler{
+		queues:   make(map[string]*queue),
+		keys:     make(map[string]*key),
+		rates:    make(map[string]*rateLimit),
+		perKey:   1,
+		clock:    realClock{},
+		workers:  n,
+		limit:    n,
+		done:     make(chan struct{}),
+		run:      run,
+		dispatch: dispatch,
+		expire:   expire,
+	}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// push adds a job to the line, behind jobs with the same or higher priority.
+// When the line is full the queue policy decides what happens: with QueueBlock
+// we wait for room (or return ErrQueueFull if block is false, ErrPoolStopped if
+// we stop while waiting), otherwise we return ErrQueueFull or the jobs that were
+// dropped to make room, which may be just j. More than one job is dropped when
+// the limit was lowered while jobs were waiting.
+func (s *scheduler) push(ctx context.Context, j *Job, block bool) (dropped []*Job, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.maxQ > 0 && s.waiting >= s.maxQ {
+		switch s.policy {
+		case QueueReject:
+			return nil, ErrQueueFull
+		case QueueDropNewest:
+			return []*Job{j}, nil
+		case QueueDropOldest:
+			dropped = append(dropped, s.removeOldest())
+			continue
+		}
+		if !block {
+			return nil, ErrQueueFull
+		}
+		if s.stopped {
+			// Nothing will make room anymore
+			return nil, ErrPoolStopped
+		}
+		if s.space == nil {
+			s.space = make(chan struct{})
+		}
+		space := s.space
+		s.mu.Unlock()
+		select {
+		case <-space:
+			s.mu.Lock()
+		case <-ctx.Done():
+			s.mu.Lock()
+			return nil, ctx.Err()
+		}
+	}
+
+	s.seq++
+	j.seq = s.seq
+	// With aging, a job's priority grows by 1 every `aging` it waits. Comparing
+	// two waiting jobs, the time they have both waited cancels out, so ranking
+	// on when the job would have had priority 0 gives the same order forever.
+	if s.aging > 0 {
+		j.rank = s.clock.Now().UnixNano() - int64(j.Priority)*int64(s.aging)
+	} else {
+		j.rank = -int64(j.Priority)
+	}
+	s.waiting++
+	// The dispatch funcs dropped jobs gave back are not needed anymore, see remove
+	if s.idle > s.waiting {
+		s.idle = s.waiting
+	}
+	s.admit(j)
+	s.spawn()
+	// A job that takes the place of a dropped one may need the dispatch func the dropped one gave back
+	s.wake()
+	return dropped, nil
+}
+
+// next is called by WorkerPool workers, it returns the job that may start next.
+// Returns nil if no job may start, then the worker is given back, see wake.
+func (s *scheduler) next() *Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if q := s.pick(); q != nil {
+		return s.take(q)
+	}
+	if !s.stopped && s.idle < s.waiting {
+		s.idle++
+	}
+	return nil
+}
+
+// finish is called by WorkerPool workers after running a job from next
+func (s *scheduler) finish(j *Job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.release(j)
+	s.spawn()
+	s.wake()
+}
+
+// resize changes how many jobs may run at once
+func (s *scheduler) resize(n int) {
+	if n < 1 {
+		n = 1
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limit = n
+	s.spawn()
+	s.wake()
+}
+
+// size returns how many jobs may run at once
+func (s *scheduler) size() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.limit
+}
+
+// counts returns how many jobs are waiting and running
+func (s *scheduler) counts() (waiting, running int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.waiting, s.running
+}
+
+// setAging sets how long a job waits to gain 1 priority
+func (s *scheduler) setAging(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.aging = d
+}
+
+// setClock sets the clock aging goes by
+func (s *scheduler) setClock(c Clock) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clock = c
+}
+
+// setRate sets the pool rate limit
+func (s *scheduler) setRate(l RateLimit) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rate = &rateLimit{bucket: newTokenBucket(l, s.clock.Now())}
+	s.spawn()
+	s.wake()
+}
+
+// setKeyRate sets the rate limit of jobs with Job.RateKey key
+func (s *scheduler) setKeyRate(key string, l RateLimit) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.rates[key]
+	if !ok {
+		r = &rateLimit{}
+		s.rates[key] = r
+	}
+	r.bucket = newTokenBucket(l, s.clock.Now())
+	s.unparkRate(r)
+	s.spawn()
+	s.wake()
+}
+
+// setMaxQueue sets how many jobs may wait and what happens when that many are
+func (s *scheduler) setMaxQueue(n int, policy QueuePolicy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxQ, s.policy = n, policy
+	s.wakeBlocked()
+}
+
+// setCapacity sets how much of each resource running jobs may use in total
+func (s *scheduler) setCapacity(c Resources) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cap = make(Resources, len(c))
+	for r, n := range c {
+		s.cap[r] = n
+	}
+	s.spawn()
+	s.wake()
+}
+
+// setPaused pauses or resumes starting jobs
+func (s *scheduler) setPaused(paused bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paused = paused
+	s.spawn()
+	s.wake()
+}
+
+// isPaused reports if starting jobs is paused
+func (s *scheduler) isPaused() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.paused
+}
+
+// setQueue sets the weight and limit of a named queue
+func (s *scheduler) setQueue(name string, weight, max int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q := s.queue(name)
+	q.weight, q.max = atLeastOne(weight), max
+	s.spawn()
+	s.wake()
+}
+
+// queueStats returns the stats of every queue
+func (s *scheduler) queueStats() map[string]QueueStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := make(map[string]QueueStats, len(s.queues))
+	for name, q := range s.queues {
+		stats[name] = QueueStats{
+			Waiting:   q.jobs.Len() + q.parked,
+			Running:   q.running,
+			Completed: q.completed,
+			Failed:    q.failed,
+		}
+	}
+	return stats
+}
+
+// setPerKey sets how many jobs with the same ConcurrencyKey may run at once
+func (s *scheduler) setPerKey(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.perKey = atLeastOne(n)
+	for _, k := range s.keys {
+		for k.active < s.perKey && len(k.parked) > 0 {
+			s.unpark(k)
+		}
+	}
+	s.spawn()
+	s.wake()
+}
+
+// queue returns the named queue, creating it if need be. Must hold s.mu.
+func (s *scheduler) queue(name string) *queue {
+	q, ok := s.queues[name]
+	if !ok {
+		q = &queue{weight: 1}
+		s.queues[name] = q
+	}
+	return q
+}
+
+// stop keeps any more jobs from starting
+func (s *scheduler) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setStopped()
+	s.cond.Broadcast()
+	s.wakeBlocked()
+}
+
+// setStopped keeps any more jobs from starting and rate limit timers from waiting. Must hold s.mu.
+func (s *scheduler) setStopped() {
+	if !s.stopped {
+		s.stopped = true
+		close(s.done)
+	}
+}
+
+// drain removes every job still waiting and returns them in the order they were submitted
+func (s *scheduler) drain() []*Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var jobs []*Job
+	for _, q := range s.queues {
+		jobs = append(jobs, q.jobs...)
+		q.jobs, q.parked = nil, 0
+	}
+	for _, k := range s.keys {
+		jobs = append(jobs, k.parked...)
+	}
+	for _, r := range s.rates {
+		jobs = append(jobs, r.parked...)
+		r.parked = nil
+	}
+	for _, j := range jobs {
+		s.leave(j)
+	}
+	s.keys = make(map[string]*key)
+	s.waiting = 0
+	sort.Slice(jobs, func(a, b int) bool { return jobs[a].seq < jobs[b].seq })
+	return jobs
+}
+
+// settle waits until every job has finished, or we are stopped, then stops us so
+// no more dispatch funcs are queued. The WorkerPool may be stopped after that.
+func (s *scheduler) settle() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for !s.stopped && (s.waiting > 0 || s.running > 0) {
+		s.cond.Wait()
+	}
+	s.setStopped()
+}
+
+// wait waits for extra runners to exit
+func (s *scheduler) wait() {
+	s.runners.Wait()
+}
+
+// pick returns the queue to take the next job from, nil if no job may start.
+// It is the queue furthest behind on its share of turns, among those with jobs
+// waiting that are under their own limit. Jobs whose RateKey has no token are
+// parked on the way. Must hold s.mu.
+func (s *scheduler) pick() *queue {
+	if s.stopped || s.paused || s.waiting == 0 || s.running >= s.limit {
+		return nil
+	}
+	now := s.clock.Now()
+	var next *queue
+	for _, q := range s.queues {
+		if q.max > 0 && q.running >= q.max {
+			continue
+		}
+		s.parkRate(q, now)
+		if q.jobs.Len() == 0 {
+			continue
+		}
+		if next == nil || q.pass < next.pass || (q.pass == next.pass && before(q.jobs[0], next.jobs[0])) {
+			next = q
+		}
+	}
+	if next == nil || !s.fits(next.jobs[0]) {
+		return nil
+	}
+	if s.rate != nil {
+		if d := s.rate.bucket.due(now); d > 0 {
+			s.arm(s.rate, d)
+			s.watch(next.jobs[0])
+			return nil
+		}
+	}
+	return next
+}
+
+// fits reports if there are enough resources left for a job. A job that needs more
+// than the capacity of a resource fits once no running job uses that resource,
+// otherwise it would never start. Must hold s.mu.
+func (s *scheduler) fits(j *Job) bool {
+	for r, n := range j.Resources {
+		c, ok := s.cap[r]
+		if ok && s.used[r] > 0 && s.used[r]+n > c {
+			return false
+		}
+	}
+	return true
+}
+
+// take removes the next job from a queue and counts it as running. Must hold s.mu.
+func (s *scheduler) take(q *queue) *Job {
+	s.waiting--
+	s.running++
+	s.wakeBlocked()
+	j := heap.Pop(&q.jobs).(*Job)
+	s.leave(j)
+	// pick made sure there are tokens
+	if r := s.keyRate(j); r != nil {
+		r.bucket.take()
+	}
+	if s.rate != nil {
+		s.rate.bucket.take()
+	}
+	q.running++
+	s.vtime = q.pass
+	q.pass += 1 / float64(q.weight)
+	for r, n := range j.Resources {
+		if s.used == nil {
+			s.used = make(Resources)
+		}
+		s.used[r] += n
+	}
+	return j
+}
+
+// release counts a job taken with take as no longer running. Must hold s.mu.
+func (s *scheduler) release(j *Job) {
+	s.running--
+	q := s.queues[j.Queue]
+	q.running--
+	q.completed++
+	if j.future.Status() != StatusSucceeded {
+		q.failed++
+	}
+	s.unkey(j)
+	for r, n := range j.Resources {
+		s.used[r] -= n
+	}
+	s.cond.Broadcast()
+}
+
+// remove takes a job out of the line, it reports false if the job is not waiting
+func (s *scheduler) remove(j *Job) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	at, ok := s.locate(j)
+	if !ok {
+		return false
+	}
+	s.removeAt(j, at)
+	// The dispatch func the job may have given back is not needed anymore
+	if s.idle > s.waiting {
+		s.idle = s.waiting
+	}
+	s.wakeBlocked()
+	// A job parked behind it may be able to start now
+	s.wake()
+	s.cond.Broadcast()
+	return true
+}
+
+// locate returns where a waiting job is, ok is false if the job is not waiting. Must hold s.mu.
+func (s *scheduler) locate(j *Job) (at spot, ok bool) {
+	if q, ok := s.queues[j.Queue]; ok {
+		for i, w := range q.jobs {
+			if w == j {
+				return spot{q: q, i: i}, true
+			}
+		}
+	}
+	if k, ok := s.keys[j.ConcurrencyKey]; ok {
+		for i, w := range k.parked {
+			if w == j {
+				return spot{k: k, i: i}, true
+			}
+		}
+	}
+	if r := s.keyRate(j); r != nil {
+		for i, w := range r.parked {
+			if w == j {
+				return spot{r: r, i: i}, true
+			}
+		}
+	}
+	return spot{}, false
+}
+
+// removeOldest removes the first submitted job from the line, whatever its priority. Must hold s.mu.
+func (s *scheduler) removeOldest() *Job {
+	var oldest *Job
+	var from spot
+	for _, q := range s.queues {
+		for i, j := range q.jobs {
+			if oldest == nil || j.seq < oldest.seq {
+				oldest, from = j, spot{q: q, i: i}
+			}
+		}
+	}
+	for _, k := range s.keys {
+		for i, j := range k.parked {
+			if oldest == nil || j.seq < oldest.seq {
+				oldest, from = j, spot{k: k, i: i}
+			}
+		}
+	}
+	for _, r := range s.rates {
+		for i, j := range r.parked {
+			if oldest == nil || j.seq < oldest.seq {
+				oldest, from = j, spot{r: r, i: i}
+			}
+		}
+	}
+
+	s.removeAt(oldest, from)
+	return oldest
+}
+
+// removeAt removes a waiting job from where it is. Must hold s.mu.
+func (s *scheduler) removeAt(j *Job, at spot) {
+	s.waiting--
+	s.leave(j)
+	switch {
+	case at.k != nil:
+		at.k.parked = append(at.k.parked[:at.i], at.k.parked[at.i+1:]...)
+		s.queues[j.Queue].parked--
+		s.forget(j.ConcurrencyKey)
+	case at.r != nil:
+		// It was let in by its ConcurrencyKey before it was parked
+		at.r.parked = append(at.r.parked[:at.i], at.r.parked[at.i+1:]...)
+		s.queues[j.Queue].parked--
+		s.unkey(j)
+	default:
+		heap.Remove(&at.q.jobs, at.i)
+		s.unkey(j)
+	}
+}
+
+// admit puts a waiting job in its queue, or parks it while too many jobs with
+// its ConcurrencyKey are queued or running. Must hold s.mu.
+func (s *scheduler) admit(j *Job) {
+	if j.ConcurrencyKey != "" {
+		k, ok := s.keys[j.ConcurrencyKey]
+		if !ok {
+			k = &key{}
+			s.keys[j.ConcurrencyKey] = k
+		}
+		if k.active >= s.perKey {
+			k.parked = append(k.parked, j)
+			s.queue(j.Queue).parked++
+			return
+		}
+		k.active++
+	}
+	s.enqueue(j)
+}
+
+// enqueue puts a job in its queue's line. Must hold s.mu.
+func (s *scheduler) enqueue(j *Job) {
+	q := s.queue(j.Queue)
+	// A queue that had nothing waiting doesn't get to catch up on the turns it
+	// didn't need, it joins in where the others are
+	if q.jobs.Len() == 0 && q.pass < s.vtime {
+		q.pass = s.vtime
+	}
+	heap.Push(&q.jobs, j)
+}
+
+// unkey is called when a job leaves its queue without running or finishes,
+// it makes room for the next parked job with the same ConcurrencyKey. Must hold s.mu.
+func (s *scheduler) unkey(j *Job) {
+	k, ok := s.keys[j.ConcurrencyKey]
+	if !ok {
+		return
+	}
+	k.active--
+	if len(k.parked) > 0 {
+		s.unpark(k)
+	}
+	s.forget(j.ConcurrencyKey)
+}
+
+// unpark moves the parked job that should start first into its queue. Must hold s.mu.
+func (s *scheduler) unpark(k *key) {
+	next := 0
+	for i, j := range k.parked {
+		if before(j, k.parked[next]) {
+			next = i
+		}
+	}
+	j := k.parked[next]
+	k.parked = append(k.parked[:next], k.parked[next+1:]...)
+	s.queues[j.Queue].parked--
+	s.admit(j)
+}
+
+// forget stops tracking a key once it has no jobs. Must hold s.mu.
+func (s *scheduler) forget(name string) {
+	if k, ok := s.keys[name]; ok && k.active == 0 && len(k.parked) == 0 {
+		delete(s.keys, name)
+	}
+}
+
+// keyRate returns the rate limit of a job's RateKey, nil if it has none. Must hold s.mu.
+func (s *scheduler) keyRate(j *Job) *rateLimit {
+	if j.RateKey == "" {
+		return nil
+	}
+	return s.rates[j.RateKey]
+}
+
+// parkRate parks the jobs at the front of a queue while their RateKey has no token,
+// so they don't hold back the jobs behind them. Must hold s.mu.
+func (s *scheduler) parkRate(q *queue, now time.Time) {
+	for q.jobs.Len() > 0 {
+		j := q.jobs[0]
+		r := s.keyRate(j)
+		if r == nil {
+			return
+		}
+		d := r.bucket.due(now)
+		if d == 0 {
+			return
+		}
+		heap.Pop(&q.jobs)
+		q.parked++
+		r.parked = append(r.parked, j)
+		s.arm(r, d)
+		s.watch(j)
+	}
+}
+
+// unparkRate puts the jobs waiting for a token of r back in their queues, pick
+// parks them again if there are not enough tokens for all of them. Must hold s.mu.
+func (s *scheduler) unparkRate(r *rateLimit) {
+	for _, j := range r.parked {
+		s.queues[j.Queue].parked--
+		s.enqueue(j)
+	}
+	r.parked = nil
+}
+
+// arm starts a timer that wakes us once d has passed and r has a token again,
+// unless r has one already. Must hold s.mu.
+func (s *scheduler) arm(r *rateLimit, d time.Duration) {
+	if r.armed {
+		return
+	}
+	r.armed = true
+	t := s.clock.NewTimer(d)
+	done := s.done
+	go func() {
+		select {
+		case <-t.C():
+		case <-done:
+			t.Stop()
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		r.armed = false
+		s.unparkRate(r)
+		s.spawn()
+		s.wake()
+	}()
+}
+
+// watch takes a job waiting for a token out of the line once its Context is done,
+// see expire. Must hold s.mu.
+func (s *scheduler) watch(j *Job) {
+	if j.gone != nil || j.Context.Done() == nil {
+		return
+	}
+	gone := make(chan struct{})
+	j.gone = gone
+	go func() {
+		select {
+		case <-j.Context.Done():
+			s.expire(j)
+		case <-gone:
+		}
+	}()
+}
+
+// leave is called when a job leaves the line, we stop watching its Context. Must hold s.mu.
+func (s *scheduler) leave(j *Job) {
+	if j.gone != nil {
+		close(j.gone)
+		j.gone = nil
+	}
+}
+
+// wake queues dispatch funcs in place of those that were given back, as many as
+// jobs may start now. Must hold s.mu.
+func (s *scheduler) wake() {
+	if s.idle == 0 || s.pick() == nil {
+		return
+	}
+	n := s.limit - s.running
+	if n > s.idle {
+		n = s.idle
+	}
+	s.idle -= n
+	for ; n > 0; n-- {
+		s.dispatch()
+	}
+}
+
+// wakeBlocked wakes anyone blocked on a full queue since there may be room now. Must hold s.mu.
+func (s *scheduler) wakeBlocked() {
+	if s.space != nil {
+		close(s.space)
+		s.space = nil
+	}
+}
+
+// spawn starts extra runners while we are allowed to run more jobs than
+// there are WorkerPool workers. Must hold s.mu.
+func (s *scheduler) spawn() {
+	for s.extra < s.limit-s.workers {
+		q := s.pick()
+		if q == nil {
+			return
+		}
+		s.extra++
+		s.runners.Add(1)
+		go s.runExtra(s.take(q))
+	}
+}
+
+// runExtra runs jobs until none may start or there are too many extra runners
+func (s *scheduler) runExtra(j *Job) {
+	defer s.runners.Done()
+	for j != nil {
+		s.run(j)
+
+		s.mu.Lock()
+		s.release(j)
+		j = nil
+		if s.extra <= s.limit-s.workers {
+			if q := s.pick(); q != nil {
+				j = s.take(q)
+			}
+		}
+		if j == nil {
+			s.extra--
+		}
+		s.wake()
+		s.mu.Unlock()
+	}
+}
+
+// key tracks the jobs with a ConcurrencyKey
+type key struct {
+	active int    // active is how many of its jobs are queued or running
+	parked []*Job // parked holds its jobs that wait for active to go down before being queued
+}
+
+// spot is where a waiting job is, in its queue's line or parked on its
+// ConcurrencyKey or RateKey, at index i of the line or parked jobs
+type spot struct {
+	q *queue
+	k *key
+	r *rateLimit
+	i int
+}
+
+// queue is a named line of jobs
+type queue struct {
+	jobs      jobQueue // jobs holds jobs that have not started yet
+	parked    int      // parked is how many of its jobs wait for their ConcurrencyKey or a RateKey token
+	weight    int      // weight is how many turns the queue gets for every turn of a queue with weight 1
+	max       int      // max is how many of its jobs may run at once, 0 means no limit
+	running   int      // running is how many of its jobs are running
+	completed int      // completed is how many of its jobs finished
+	failed    int      // failed is how many of the finished jobs had an error
+	pass      float64  // pass grows by 1/weight every turn, the queue with the lowest pass goes next
+}
+
+// jobQueue is a heap of waiting jobs, lowest rank first then first submitted
+type jobQueue []*Job
+
+func (q jobQueue) Len() int {
+	return len(q)
+}
+
+func (q jobQueue) Less(a, b int) bool {
+	return before(q[a], q[b])
+}
+
+func (q jobQueue) Swap(a, b int) {
+	q[a], q[b] = q[b], q[a]
+}
+
+func (q *jobQueue) Push(x interface{}) {
+	*q = append(*q, x.(*Job))
+}
+
+func (q *jobQueue) Pop() interface{} {
+	old := *q
+	j := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return j
+}
+
+// before reports if job a should start before job b
+func before(a, b *Job) bool {
+	if a.rank != b.rank {
+		return a.rank < b.rank
+	}
+	return a.seq < b.seq
+}
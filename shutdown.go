@@ -0,0 +1,119 @@
+package workerpoolxt
+
+import (
+	"context"
+	"sync"
+)
+
+// Shutdown stops accepting jobs and waits for every job already submitted,
+// including scheduled jobs, to finish. A paused pool is resumed. If ctx is
+// done first, jobs that have not started never will (their Result.Error is
+// ErrJobNotRun) and running jobs have their context cancelled (their
+// Result.Error is ErrCancelledAtShutdown), then Shutdown returns the ctx error.
+//
+// Like StopWaitXT, every job has a Result, and nothing is returned
+// when streaming since results have already been delivered on Results().
+func (p *WorkerPoolXT) Shutdown(ctx context.Context) (rs []Result, err error) {
+	p.once.Do(func() {
+		p.quitting()
+		p.Resume()
+
+		drained := make(chan struct{})
+		watched := make(chan struct{})
+		go func() {
+			defer close(watched)
+			select {
+			case <-ctx.Done():
+				err = ctx.Err()
+				p.halt()
+				p.running.cancelAll()
+			case <-drained:
+			}
+		}()
+
+		p.delayed.Wait()
+		p.sched.settle()
+		p.StopWait()
+		p.sched.wait()
+		close(drained)
+		<-watched
+		p.closeResults()
+	})
+	return p.results, err
+}
+
+// halt stops jobs from starting, scheduled jobs give up waiting
+func (p *WorkerPoolXT) halt() {
+	close(p.abandon)
+	p.sched.stop()
+}
+
+// quitting starts stopping, once it returns nothing else enters
+func (p *WorkerPoolXT) quitting() {
+	p.entry.Lock()
+	defer p.entry.Unlock()
+	close(p.quit)
+}
+
+// enter counts a job being submitted (or waiting for its time) in delayed, so
+// stopping waits for it to be submitted before the WorkerPool is stopped. It
+// returns false once stopping, the job must not be submitted then.
+func (p *WorkerPoolXT) enter() bool {
+	p.entry.Lock()
+	defer p.entry.Unlock()
+	if p.stopping() {
+		return false
+	}
+	p.delayed.Add(1)
+	return true
+}
+
+// stopping reports if the pool has started stopping
+func (p *WorkerPoolXT) stopping() bool {
+	select {
+	case <-p.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// runningJobs tracks running jobs so Shutdown can cancel them
+type runningJobs struct {
+	mu        sync.Mutex
+	jobs      map[*Job]bool // jobs maps each running job to whether Shutdown cancelled it
+	cancelled bool          // cancelled means every job is cancelled as soon as it starts
+}
+
+// add tracks a job that just started
+func (r *runningJobs) add(j *Job) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.jobs == nil {
+		r.jobs = make(map[*Job]bool)
+	}
+	r.jobs[j] = r.cancelled
+	if r.cancelled {
+		j.future.Cancel()
+	}
+}
+
+// remove stops tracking a job that finished and reports if Shutdown cancelled it
+func (r *runningJobs) remove(j *Job) (cancelled bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cancelled = r.jobs[j]
+	delete(r.jobs, j)
+	return cancelled
+}
+
+// cancelAll cancels every running job and any job that starts from now on
+func (r *runningJobs) cancelAll() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cancelled = true
+	for j := range r.jobs {
+		r.jobs[j] = true
+		j.future.Cancel()
+	}
+}
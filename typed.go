@@ -0,0 +1,147 @@
+package workerpoolxt
+
+import (
+	"context"
+	"reflect"
+	"time"
+)
+
+// TypedJob is a Job whose task takes options of type I and returns data of type O.
+// Everything else (Name, Context, Retry, ...) is configured on the embedded Job,
+// whose Task, TaskCtx and Options are ignored.
+type TypedJob[I, O any] struct {
+	Job
+	Task    func(I) TypedResult[O]
+	TaskCtx func(context.Context, I) TypedResult[O]
+	Options I
+}
+
+// TypedResult holds typed job results
+type TypedResult[O any] struct {
+	Error     error
+	Data      O
+	name      string
+	duration  time.Duration
+	retryStop RetryStopReason
+	attempts  []Attempt
+}
+
+// Duration returns the amount of time it took to run the job
+func (r *TypedResult[O]) Duration() time.Duration {
+	return r.duration
+}
+
+// Name returns the job name
+func (r *TypedResult[O]) Name() string {
+	return r.name
+}
+
+// Attempts returns every attempt made at running the job, in order
+func (r *TypedResult[O]) Attempts() []Attempt {
+	return r.attempts
+}
+
+// RetryStopReason returns why the job stopped retrying
+func (r *TypedResult[O]) RetryStopReason() RetryStopReason {
+	return r.retryStop
+}
+
+// TypedPool wraps WorkerPoolXT so jobs take options of type I and return data of type O
+type TypedPool[I, O any] struct {
+	xt      *WorkerPoolXT
+	options I
+}
+
+// NewTyped creates a TypedPool
+func NewTyped[I, O any](ctx context.Context, maxWorkers int) *TypedPool[I, O] {
+	return &TypedPool[I, O]{xt: New(ctx, maxWorkers)}
+}
+
+// NewTypedWithOptions creates a TypedPool with default options
+func NewTypedWithOptions[I, O any](ctx context.Context, maxWorkers int, o I) *TypedPool[I, O] {
+	p := NewTyped[I, O](ctx, maxWorkers)
+	p.options = o
+	return p
+}
+
+// XT returns the underlying WorkerPoolXT
+func (p *TypedPool[I, O]) XT() *WorkerPoolXT {
+	return p.xt
+}
+
+// SubmitXT submits a typed job which you can get a result from
+func (p *TypedPool[I, O]) SubmitXT(j TypedJob[I, O]) {
+	p.SubmitFutureXT(j)
+}
+
+// SubmitFutureXT submits a typed job and returns a TypedFuture for that specific job
+func (p *TypedPool[I, O]) SubmitFutureXT(j TypedJob[I, O]) *TypedFuture[O] {
+	return &TypedFuture[O]{Future: p.xt.SubmitFutureXT(p.toJob(j))}
+}
+
+// SubmitWaitXT submits a typed job and waits for its result
+func (p *TypedPool[I, O]) SubmitWaitXT(j TypedJob[I, O]) TypedResult[O] {
+	return toTypedResult[O](p.xt.SubmitWaitXT(p.toJob(j)))
+}
+
+// StopWaitXT gets typed results then kills the worker pool
+func (p *TypedPool[I, O]) StopWaitXT() []TypedResult[O] {
+	rs := p.xt.StopWaitXT()
+	typed := make([]TypedResult[O], len(rs))
+	for i, r := range rs {
+		typed[i] = toTypedResult[O](r)
+	}
+	return typed
+}
+
+// toJob converts a typed job into a Job. Like untyped jobs, job options
+// override default options when they are set, we do NOT merge them.
+func (p *TypedPool[I, O]) toJob(tj TypedJob[I, O]) Job {
+	o := tj.Options
+	if reflect.ValueOf(&o).Elem().IsZero() {
+		o = p.options
+	}
+	task, taskCtx := tj.Task, tj.TaskCtx
+
+	j := tj.Job
+	j.Task = nil
+	j.TaskCtx = func(ctx context.Context, _ Options) Result {
+		var r TypedResult[O]
+		if taskCtx != nil {
+			r = taskCtx(ctx, o)
+		} else {
+			r = task(o)
+		}
+		return Result{Error: r.Error, Data: r.Data}
+	}
+	return j
+}
+
+// TypedFuture is a Future whose result is typed
+type TypedFuture[O any] struct {
+	*Future
+}
+
+// Wait blocks until the job result is available or ctx is done,
+// whichever happens first
+func (f *TypedFuture[O]) Wait(ctx context.Context) (TypedResult[O], error) {
+	r, err := f.Future.Wait(ctx)
+	if err != nil {
+		return TypedResult[O]{}, err
+	}
+	return toTypedResult[O](r), nil
+}
+
+// toTypedResult converts a Result into a TypedResult. Data is left as the zero
+// value of O when the job did not return any (e.g. it timed out).
+func toTypedResult[O any](r Result) TypedResult[O] {
+	data, _ := r.Data.(O)
+	return TypedResult[O]{
+		Error:     r.Error,
+		Data:      data,
+		name:      r.name,
+		duration:  r.duration,
+		retryStop: r.retryStop,
+		attempts:  r.attempts,
+	}
+}
@@ -10,12 +10,18 @@ import (
 
 // New creates WorkerPoolXT
 func New(ctx context.Context, maxWorkers int) *WorkerPoolXT {
-	p := &WorkerPoolXT{
-		WorkerPool: workerpool.New(maxWorkers),
-		context:    ctx,
-		result:     make(chan Result),
-		kill:       make(chan struct{}),
-	}
+	p := newPool(ctx, maxWorkers)
+	go p.processResults()
+	return p
+}
+
+// NewStreaming creates WorkerPoolXT which delivers each result on Results()
+// as soon as its job finishes, instead of holding results until StopWaitXT.
+// Results() is unbuffered, so a slow consumer applies backpressure: the worker
+// that produced a result waits until it is received before taking more work.
+func NewStreaming(ctx context.Context, maxWorkers int) *WorkerPoolXT {
+	p := newPool(ctx, maxWorkers)
+	p.stream = make(chan Result)
 	go p.processResults()
 	return p
 }
@@ -33,22 +39,222 @@ type WorkerPoolXT struct {
 	context context.Context
 	kill    chan struct{}
 	options Options
+	retry   RetryPolicy
+	retryIf func(error) bool
 	once    sync.Once
 	result  chan Result
 	results []Result
+	stream  chan Result
+	sched   *scheduler
+	stats   jobStats
+	running runningJobs
+	dedupe  dedupe
+	quit    chan struct{}  // quit is closed when stopping, recurring jobs and autoscaling stop
+	abandon chan struct{}  // abandon is closed when stopping now, scheduled jobs give up waiting
+	delayed sync.WaitGroup // delayed counts jobs being submitted, scheduled and recurring jobs waiting for their time, and discarded jobs
+	entry   sync.Mutex     // entry is held to close quit and to add to delayed, see enter
+	clock   Clock
+}
+
+// newPool creates WorkerPoolXT without starting it
+func newPool(ctx context.Context, maxWorkers int) *WorkerPoolXT {
+	p := &WorkerPoolXT{
+		WorkerPool: workerpool.New(maxWorkers),
+		context:    ctx,
+		result:     make(chan Result),
+		kill:       make(chan struct{}),
+		quit:       make(chan struct{}),
+		abandon:    make(chan struct{}),
+		clock:      realClock{},
+	}
+	p.sched = newScheduler(maxWorkers, func(j *Job) { p.wrap(j)() }, func() { p.Submit(p.dispatch) }, func(j *Job) { p.unqueue(j, j.Context.Err()) })
+	return p
 }
 
 // SubmitXT submits a job which you can get a result from
 func (p *WorkerPoolXT) SubmitXT(j Job) {
-	p.Submit(p.wrap(&j))
+	p.SubmitFutureXT(j)
+}
+
+// SubmitFutureXT submits a job and returns a Future for that specific job.
+// The result is still collected by the pool like it is with SubmitXT.
+func (p *WorkerPoolXT) SubmitFutureXT(j Job) *Future {
+	j.future = newFuture(j.Name)
+	p.accept(context.Background(), &j, true, true)
+	return j.future
+}
+
+// TrySubmitXT submits a job without waiting for room in a full queue, see SetMaxQueue.
+// It returns ErrQueueFull (or ErrPoolStopped once stopping) if the job was not
+// accepted, in which case it has no Result.
+func (p *WorkerPoolXT) TrySubmitXT(j Job) error {
+	j.future = newFuture(j.Name)
+	return p.accept(context.Background(), &j, false, false)
+}
+
+// SubmitXTCtx submits a job, waiting for room in a full queue until ctx is done,
+// see SetMaxQueue. It returns ErrQueueFull, ErrPoolStopped or the ctx error if the
+// job was not accepted, or ErrJobNotRun if the pool stopped while it waited for
+// room. The job has no Result in either case. ctx is not the job context.
+func (p *WorkerPoolXT) SubmitXTCtx(ctx context.Context, j Job) error {
+	j.future = newFuture(j.Name)
+	return p.accept(ctx, &j, true, false)
+}
+
+// SubmitWaitXT submits a job and waits for its result. The result is still
+// collected by the pool like it is with SubmitXT.
+func (p *WorkerPoolXT) SubmitWaitXT(j Job) Result {
+	r, _ := p.SubmitFutureXT(j).Wait(context.Background())
+	return r
+}
+
+// SubmitWaitXTCtx submits a job and waits for its result until ctx is done.
+// ctx also bounds waiting for room in a full queue, like SubmitXTCtx. When ctx
+// is done first the job is cancelled and the ctx error is returned.
+func (p *WorkerPoolXT) SubmitWaitXTCtx(ctx context.Context, j Job) (Result, error) {
+	own := newFuture(j.Name)
+	j.future = own
+	if err := p.accept(ctx, &j, true, false); err != nil {
+		return Result{}, err
+	}
+	r, err := j.future.Wait(ctx)
+	// Don't cancel a job we share with others through its IdempotencyKey
+	if err != nil && j.future == own {
+		j.future.Cancel()
+	}
+	return r, err
+}
+
+// SubmitAfter submits a job once d has passed. Cancel the returned Future to
+// cancel the job before it is submitted. StopWaitXT waits for scheduled jobs
+// to be submitted and run, stopping now gives them ErrJobNotRun instead.
+func (p *WorkerPoolXT) SubmitAfter(d time.Duration, j Job) *Future {
+	j.future = newFuture(j.Name)
+	if !p.enter() {
+		j.future.complete(Result{Error: ErrPoolStopped, name: j.Name})
+		return j.future
+	}
+	if !p.claim(&j) {
+		p.delayed.Done()
+		return j.future
+	}
+	timer := p.clock.NewTimer(d)
+	cancelled := make(chan struct{})
+	j.future.onCancel(func() { close(cancelled) })
+
+	go func() {
+		defer p.delayed.Done()
+		select {
+		case <-timer.C():
+			p.submitDue(&j)
+		case <-cancelled:
+			timer.Stop()
+			p.notRun(&j, context.Canceled)
+		case <-p.abandon:
+			timer.Stop()
+			p.notRun(&j, ErrJobNotRun)
+		}
+	}()
+	return j.future
 }
 
-// StopWaitXT gets results then kills the worker pool
+// SubmitAt submits a job at time t, see SubmitAfter
+func (p *WorkerPoolXT) SubmitAt(t time.Time, j Job) *Future {
+	return p.SubmitAfter(t.Sub(p.clock.Now()), j)
+}
+
+// StopWaitXT gets results then kills the worker pool.
+// When streaming, results have already been delivered on Results()
+// so nothing is returned here.
 func (p *WorkerPoolXT) StopWaitXT() (rs []Result) {
 	p.stop(false)
 	return p.results
 }
 
+// StopXT stops the pool now. Running jobs are allowed to finish but nothing
+// else starts, jobs that never ran have a Result with ErrJobNotRun, so there is
+// still a Result for every job submitted. When streaming, results have already
+// been delivered on Results() so nothing is returned here.
+func (p *WorkerPoolXT) StopXT() []Result {
+	p.stop(true)
+	return p.results
+}
+
+// Resize changes how many jobs submitted with SubmitXT may run at once. It is safe
+// to call while jobs are running: growing starts waiting jobs right away, shrinking
+// lets running jobs finish but holds new ones back until we are under n.
+// Tasks submitted with Submit are not affected and are still limited to maxWorkers,
+// workers are not held by jobs that are held back.
+func (p *WorkerPoolXT) Resize(n int) {
+	p.sched.resize(n)
+}
+
+// Size returns how many jobs submitted with SubmitXT may run at once
+func (p *WorkerPoolXT) Size() int {
+	return p.sched.size()
+}
+
+// Pause holds jobs submitted with SubmitXT back until Resume is called, running
+// jobs are allowed to finish. Jobs may still be submitted while paused, and
+// scheduled or recurring jobs still come due, they just wait to start. Stopping
+// the pool resumes it. Tasks submitted with Submit still run while paused, to
+// pause the underlying WorkerPool use WorkerPool.Pause.
+func (p *WorkerPoolXT) Pause() {
+	p.sched.setPaused(true)
+}
+
+// Resume lets jobs start again after Pause
+func (p *WorkerPoolXT) Resume() {
+	p.sched.setPaused(false)
+}
+
+// IsPaused reports if the pool is paused
+func (p *WorkerPoolXT) IsPaused() bool {
+	return p.sched.isPaused()
+}
+
+// SetKeyConcurrency sets how many jobs with the same Job.ConcurrencyKey may run
+// at once, 1 by default so they run one after the other. Jobs waiting for their
+// key don't hold back jobs with other keys. It is safe to call while jobs are running.
+func (p *WorkerPoolXT) SetKeyConcurrency(n int) {
+	p.sched.setPerKey(n)
+}
+
+// SetPriorityAging makes waiting jobs gain 1 priority every d they wait, so low
+// priority jobs are not starved by a steady stream of higher priority ones.
+// Aging is off (d = 0) by default. It should be called before submitting jobs.
+func (p *WorkerPoolXT) SetPriorityAging(d time.Duration) {
+	p.sched.setAging(d)
+}
+
+// SetClock sets the Clock used for scheduled and recurring jobs, rate limits,
+// autoscaling and priority aging. It is meant for tests and should be called
+// before submitting jobs.
+func (p *WorkerPoolXT) SetClock(c Clock) {
+	p.clock = c
+	p.sched.setClock(c)
+}
+
+// SetRetryPolicy sets the default RetryPolicy for jobs that do not have one.
+// It should be called before submitting jobs.
+func (p *WorkerPoolXT) SetRetryPolicy(rp RetryPolicy) {
+	p.retry = rp
+}
+
+// SetRetryIf sets the default RetryIf for jobs that do not have one.
+// It should be called before submitting jobs.
+func (p *WorkerPoolXT) SetRetryIf(f func(error) bool) {
+	p.retryIf = f
+}
+
+// Results returns the channel results are streamed on when the pool was
+// created with NewStreaming, otherwise nil. The channel is closed once the
+// pool has been stopped and every result has been received, so it must be
+// drained for StopWaitXT to return.
+func (p *WorkerPoolXT) Results() <-chan Result {
+	return p.stream
+}
+
 // processResults listens for results on resultsChan
 func (p *WorkerPoolXT) processResults() {
 	for {
@@ -57,44 +263,246 @@ func (p *WorkerPoolXT) processResults() {
 			if !ok {
 				goto Done
 			}
+			if p.stream != nil {
+				p.stream <- result
+				continue
+			}
 			p.results = append(p.results, result)
 		}
 	}
 Done:
+	if p.stream != nil {
+		close(p.stream)
+	}
 	<-p.kill
 }
 
 // stop either stops the worker pool now or later
 func (p *WorkerPoolXT) stop(now bool) {
 	p.once.Do(func() {
+		p.quitting()
+		if now {
+			p.halt()
+		}
+		p.Resume()
+		// Scheduled jobs have to be submitted (or give up) before we stop the WorkerPool
+		p.delayed.Wait()
+		// Jobs have to finish before we stop the WorkerPool, it can't take dispatch funcs after that
+		p.sched.settle()
 		if now {
 			p.Stop()
 		} else {
 			p.StopWait()
 		}
-		close(p.result)
-		p.kill <- struct{}{}
+		p.sched.wait()
+		p.closeResults()
 	})
 }
 
-// wrap generates the func that we pass to Submit.
+// closeResults gives jobs that never started ErrJobNotRun, then stops collecting results
+func (p *WorkerPoolXT) closeResults() {
+	for _, j := range p.sched.drain() {
+		p.notRun(j, ErrJobNotRun)
+	}
+	close(p.result)
+	p.kill <- struct{}{}
+}
+
+// accept submits a job from outside the pool, no more jobs are accepted once stopping.
+// The Future of a job that is not accepted has the error. The job only has a Result
+// if keep is true and the error is not ErrPoolStopped, since results may not be
+// collected anymore then. A job that was blocked on a full queue when we stopped
+// was accepted before we started stopping, it gets ErrJobNotRun instead.
+func (p *WorkerPoolXT) accept(ctx context.Context, j *Job, block, keep bool) error {
+	if !p.enter() {
+		j.future.complete(Result{Error: ErrPoolStopped, name: j.Name})
+		return ErrPoolStopped
+	}
+	defer p.delayed.Done()
+	if !p.claim(j) {
+		return nil
+	}
+	err := p.submit(ctx, j, block)
+	if err == nil {
+		return nil
+	}
+
+	p.dedupe.release(j.IdempotencyKey, j.future)
+	switch {
+	case err == ErrJobDropped && !keep:
+		// Not accepting the job is what dropping it means to the caller
+		err = ErrQueueFull
+	case err == ErrPoolStopped:
+		// We held off stopping while it waited for room, it just never got to run
+		err = ErrJobNotRun
+	}
+	r := Result{Error: err, name: j.Name}
+	// Submissions sharing the job were accepted, so they have a Result either way
+	n := j.future.complete(r)
+	if keep {
+		n++
+	}
+	p.sendLater(r, n)
+	return err
+}
+
+// claim claims the job's IdempotencyKey for it. If the key belongs to another job,
+// the job gets that job's Future and shares its Result instead, claim returns false
+// then and the job must not be submitted. The caller must be counted in delayed.
+func (p *WorkerPoolXT) claim(j *Job) bool {
+	key := j.IdempotencyKey
+	if key == "" {
+		return true
+	}
+	f := j.future
+	if j.future = p.dedupe.claim(key, f, p.clock.Now()); j.future != f {
+		if r, done := j.future.share(); done {
+			p.sendLater(r, 1)
+		}
+		return false
+	}
+	f.onComplete(func() { p.dedupe.finish(key, f, p.clock.Now()) })
+	return true
+}
+
+// submit hands a job to the scheduler along with a dispatch func for it.
+// An error means the job was not accepted, see scheduler.push, ErrJobDropped
+// means the queue policy dropped it.
+func (p *WorkerPoolXT) submit(ctx context.Context, j *Job, block bool) error {
+	// The scheduler watches the job Context while it waits for a rate limit token
+	if j.Context == nil {
+		j.Context = p.context
+	}
+	j.future.onCancel(func() { p.unqueue(j, context.Canceled) })
+	dropped, err := p.sched.push(ctx, j, block)
+	if err != nil {
+		return err
+	}
+	if len(dropped) > 0 && dropped[0] == j {
+		return ErrJobDropped
+	}
+	if len(dropped) > 0 {
+		// The dropped jobs' dispatch funcs run whichever job takes their place,
+		// so the number of jobs waiting (and funcs queued) does not grow
+		for _, d := range dropped {
+			p.discard(d, ErrJobDropped)
+		}
+		return nil
+	}
+	p.Submit(p.dispatch)
+	return nil
+}
+
+// submitDue submits a scheduled or recurring job that came due
+func (p *WorkerPoolXT) submitDue(j *Job) {
+	err := p.submit(context.Background(), j, true)
+	if err == nil {
+		return
+	}
+	p.dedupe.release(j.IdempotencyKey, j.future)
+	if err == ErrPoolStopped {
+		// It was submitted before we started stopping, it just never got to run
+		err = ErrJobNotRun
+	}
+	p.notRun(j, err)
+}
+
+// unqueue takes a job that was cancelled (or whose Context is done) before it
+// started out of the line and gives it its Result with err right away. Once
+// stopping, it is left to whoever stops us.
+func (p *WorkerPoolXT) unqueue(j *Job, err error) {
+	if !p.enter() {
+		return
+	}
+	defer p.delayed.Done()
+	if p.sched.remove(j) {
+		p.discard(j, err)
+	}
+}
+
+// notRun sends the result of a job that never ran
+func (p *WorkerPoolXT) notRun(j *Job, err error) {
+	p.send(j, Result{Error: err, name: j.Name})
+}
+
+// send completes the job's Future and sends its Result, once for the job and
+// once for every submission sharing it
+func (p *WorkerPoolXT) send(j *Job, r Result) {
+	for n := j.future.complete(r); n >= 0; n-- {
+		p.result <- r
+	}
+}
+
+// discard sends the result of a job that will never run without making the caller
+// wait for it to be received, see sendLater
+func (p *WorkerPoolXT) discard(j *Job, err error) {
+	r := Result{Error: err, name: j.Name}
+	p.sendLater(r, j.future.complete(r)+1)
+}
+
+// sendLater sends a Result n times without making the caller wait for it to be
+// received, which could be forever when streaming. The caller must be counted in
+// delayed, so stopping waits for the Result to be sent.
+func (p *WorkerPoolXT) sendLater(r Result, n int) {
+	if n == 0 {
+		return
+	}
+	p.delayed.Add(1)
+	go func() {
+		defer p.delayed.Done()
+		for ; n > 0; n-- {
+			p.result <- r
+		}
+	}()
+}
+
+// dispatch is the func we pass to Submit for every job submitted with SubmitXT.
+// It runs whichever job the scheduler says may start next.
+func (p *WorkerPoolXT) dispatch() {
+	if j := p.sched.next(); j != nil {
+		p.wrap(j)()
+		p.sched.finish(j)
+	}
+}
+
+// wrap generates the func that runs a job.
 func (p *WorkerPoolXT) wrap(j *Job) func() {
-	// This is the func we ultimately pass to `workerpool`
+	// This is the func we ultimately run on a `workerpool` worker (or extra runner)
 	return func() {
 		// Allow job options to override default pool options
 		if j.Options == nil {
 			j.Options = p.options
 		}
 
-		if j.Context == nil {
-			j.Context = p.context
+		if j.RetryPolicy == nil {
+			j.RetryPolicy = p.retry
+		}
+
+		if j.RetryIf == nil {
+			j.RetryIf = p.retryIf
 		}
 
 		j.childCtx, j.done = context.WithCancel(j.Context)
-		j.result = make(chan Result)
+		// Buffered so the task goroutine can always hand off its result and exit,
+		// even when we already gave up waiting on it
+		j.result = make(chan Result, 1)
 		j.startedAt = time.Now()
+		j.history = &attemptHistory{}
+
+		var r Result
+		if j.future.start(j.done) {
+			p.running.add(j)
+			go j.runDone()
+			r = j.getResult()
+			if p.running.remove(j) && r.Error != nil {
+				r.Error = ErrCancelledAtShutdown
+			}
+		} else {
+			// Cancelled before a worker picked it up, so don't run it at all
+			r = j.errResult(j.childCtx.Err())
+		}
 
-		go j.runDone()
-		p.result <- j.getResult()
+		p.stats.record(r)
+		p.send(j, r)
 	}
 }
@@ -5,10 +5,13 @@ import (
 	"errors"
 	"fmt"
 	"math/rand"
+	"reflect"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/cenkalti/backoff"
 )
 
 /**
@@ -605,6 +608,45 @@ func TestStopNow(t *testing.T) {
 	wp.stop(true)
 }
 
+func TestStopXTResultCountEqualsJobCount(t *testing.T) {
+	numJobs := 500
+	started := make(chan struct{})
+	release := make(chan struct{})
+	wp := New(freshCtx(), 1)
+	wp.SubmitXT(Job{
+		Name: "running",
+		Task: func(o Options) Result {
+			close(started)
+			<-release
+			return Result{Data: "ran"}
+		},
+	})
+	for i := 1; i < numJobs; i++ {
+		wp.SubmitXT(Job{
+			Name: fmt.Sprintf("Job %d", i),
+			Task: func(o Options) Result { return Result{Data: "ran"} },
+		})
+	}
+	<-started
+	time.AfterFunc(time.Millisecond*10, func() { close(release) })
+
+	results := wp.StopXT()
+	if len(results) != numJobs {
+		t.Fatalf("Expected %d results but got %d", numJobs, len(results))
+	}
+	for _, r := range results {
+		if r.Name() == "running" {
+			if r.Data != "ran" {
+				t.Fatalf("Expected running job to finish : got %v", r.Error)
+			}
+			continue
+		}
+		if r.Error != ErrJobNotRun {
+			t.Fatalf("Expected '%s' to have %s : got %v", r.Name(), ErrJobNotRun, r.Error)
+		}
+	}
+}
+
 func TestRetry(t *testing.T) {
 	wp := New(freshCtx(), defaultWorkers)
 	expectedError := errors.New("simulating error")
@@ -745,3 +787,2756 @@ func Test_100kJobs_1kWorkers_SleepBtwn5msAnd10msEach(t *testing.T) {
 		t.Fatalf("Expected %d : got %d", numjobs, len(results))
 	}
 }
+
+func TestStreamingResults(t *testing.T) {
+	numJobs := 50
+	wp := NewStreaming(freshCtx(), defaultWorkers)
+	for i := 0; i < numJobs; i++ {
+		ii := i
+		wp.SubmitXT(Job{
+			Name: fmt.Sprintf("Job %d", ii),
+			Task: func(o Options) Result { return Result{Data: ii} },
+		})
+	}
+
+	stopped := make(chan []Result)
+	go func() {
+		stopped <- wp.StopWaitXT()
+	}()
+
+	received := 0
+	for range wp.Results() {
+		received++
+	}
+	if received != numJobs {
+		t.Fatalf("Expected %d streamed results : got %d", numJobs, received)
+	}
+	if rs := <-stopped; len(rs) != 0 {
+		t.Fatalf("Expected StopWaitXT to return 0 results when streaming : got %d", len(rs))
+	}
+}
+
+func TestStreamingBackpressure(t *testing.T) {
+	wp := NewStreaming(freshCtx(), 1)
+	var finished int32
+	for i := 0; i < 3; i++ {
+		wp.SubmitXT(Job{
+			Name: fmt.Sprintf("Job %d", i),
+			Task: func(o Options) Result {
+				atomic.AddInt32(&finished, 1)
+				return Result{}
+			},
+		})
+	}
+
+	// Nobody is reading results, so one result is held waiting for the consumer
+	// and the single worker is stuck delivering the next one
+	time.Sleep(time.Millisecond * 50)
+	if n := atomic.LoadInt32(&finished); n != 2 {
+		t.Fatalf("Expected 2 jobs to have run while consumer is blocked : got %d", n)
+	}
+
+	go wp.StopWaitXT()
+	received := 0
+	for range wp.Results() {
+		received++
+	}
+	if received != 3 {
+		t.Fatalf("Expected 3 results : got %d", received)
+	}
+}
+
+func TestResultsIsNilWhenNotStreaming(t *testing.T) {
+	wp := makeDefaultWp()
+	if wp.Results() != nil {
+		t.Fatalf("Expected Results() to be nil when not streaming")
+	}
+	wp.StopWaitXT()
+}
+
+func TestFutureWait(t *testing.T) {
+	wp := makeDefaultWp()
+	f := wp.SubmitFutureXT(Job{
+		Name: "future",
+		Task: func(o Options) Result {
+			return Result{Data: "from future"}
+		},
+	})
+
+	r, err := f.Wait(freshCtx())
+	if err != nil {
+		t.Fatalf("Expected no error from Wait : got %s", err)
+	}
+	if r.Data != "from future" || r.Name() != "future" {
+		t.Fatalf("Expected result for job 'future' : got %v", r)
+	}
+	if f.Status() != StatusSucceeded {
+		t.Fatalf("Expected status %s : got %s", StatusSucceeded, f.Status())
+	}
+	select {
+	case <-f.Done():
+	default:
+		t.Fatalf("Expected Done() to be closed after Wait returned")
+	}
+	if len(wp.StopWaitXT()) != 1 {
+		t.Fatalf("Expected pool to still collect future results")
+	}
+}
+
+func TestFutureWaitContextExpires(t *testing.T) {
+	wp := makeDefaultWp()
+	release := make(chan struct{})
+	f := wp.SubmitFutureXT(Job{
+		Name: "slow",
+		Task: func(o Options) Result {
+			<-release
+			return Result{}
+		},
+	})
+
+	ctx, done := context.WithTimeout(freshCtx(), time.Millisecond*5)
+	defer done()
+	if _, err := f.Wait(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Expected error %s : got %v", context.DeadlineExceeded, err)
+	}
+	if f.Status() != StatusRunning {
+		t.Fatalf("Expected status %s : got %s", StatusRunning, f.Status())
+	}
+	close(release)
+	wp.StopWaitXT()
+}
+
+func TestFutureCancelRunningJob(t *testing.T) {
+	wp := makeDefaultWp()
+	started := make(chan struct{})
+	f := wp.SubmitFutureXT(Job{
+		Name: "cancel me",
+		Task: func(o Options) Result {
+			close(started)
+			time.Sleep(time.Second)
+			return Result{}
+		},
+	})
+
+	<-started
+	f.Cancel()
+	r, _ := f.Wait(freshCtx())
+	if r.Error != context.Canceled {
+		t.Fatalf("Expected error %s : got %v", context.Canceled, r.Error)
+	}
+	if f.Status() != StatusCancelled {
+		t.Fatalf("Expected status %s : got %s", StatusCancelled, f.Status())
+	}
+	wp.StopWaitXT()
+}
+
+func TestFutureCancelBeforeStart(t *testing.T) {
+	wp := New(freshCtx(), 1)
+	release := make(chan struct{})
+	wp.SubmitXT(Job{
+		Name: "blocker",
+		Task: func(o Options) Result {
+			<-release
+			return Result{}
+		},
+	})
+
+	var ran int32
+	f := wp.SubmitFutureXT(Job{
+		Name: "never runs",
+		Task: func(o Options) Result {
+			atomic.StoreInt32(&ran, 1)
+			return Result{}
+		},
+	})
+	if f.Status() != StatusPending {
+		t.Fatalf("Expected status %s : got %s", StatusPending, f.Status())
+	}
+
+	f.Cancel()
+	select {
+	case <-f.Done():
+	default:
+		t.Fatalf("Expected cancelled job to be done before a worker picks it up")
+	}
+	if f.Status() != StatusCancelled {
+		t.Fatalf("Expected status %s : got %s", StatusCancelled, f.Status())
+	}
+	if r, _ := f.Wait(freshCtx()); r.Error != context.Canceled {
+		t.Fatalf("Expected %s : got %v", context.Canceled, r.Error)
+	}
+	close(release)
+	results := wp.StopWaitXT()
+
+	if atomic.LoadInt32(&ran) != 0 {
+		t.Fatalf("Expected cancelled job to never run")
+	}
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 results : got %d", len(results))
+	}
+}
+
+func TestFutureCancelFreesQueueSlot(t *testing.T) {
+	wp, release := fillQueue(t, 1, QueueReject)
+	f := wp.SubmitFutureXT(Job{Name: "cancelled", Task: func(o Options) Result { return Result{} }})
+	f.Cancel()
+	if err := wp.TrySubmitXT(Job{Name: "next", Task: func(o Options) Result { return Result{} }}); err != nil {
+		t.Fatalf("Expected cancelled job to make room : got %v", err)
+	}
+	close(release)
+
+	results := wp.StopWaitXT()
+	if len(results) != 3 {
+		t.Fatalf("Expected 3 results : got %d", len(results))
+	}
+}
+
+type typedTestOptions struct {
+	Greeting string
+}
+
+func TestTypedPool(t *testing.T) {
+	wp := NewTypedWithOptions[typedTestOptions, string](freshCtx(), defaultWorkers, typedTestOptions{Greeting: "hello"})
+	wp.SubmitXT(TypedJob[typedTestOptions, string]{
+		Job: Job{Name: "default options"},
+		Task: func(o typedTestOptions) TypedResult[string] {
+			return TypedResult[string]{Data: o.Greeting + " world"}
+		},
+	})
+	wp.SubmitXT(TypedJob[typedTestOptions, string]{
+		Job:     Job{Name: "job options"},
+		Options: typedTestOptions{Greeting: "goodbye"},
+		Task: func(o typedTestOptions) TypedResult[string] {
+			return TypedResult[string]{Data: o.Greeting + " world"}
+		},
+	})
+
+	results := wp.StopWaitXT()
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 results : got %d", len(results))
+	}
+	for _, r := range results {
+		if r.Name() == "default options" && r.Data != "hello world" {
+			t.Fatalf("Expected 'hello world' : got '%s'", r.Data)
+		}
+		if r.Name() == "job options" && r.Data != "goodbye world" {
+			t.Fatalf("Expected 'goodbye world' : got '%s'", r.Data)
+		}
+	}
+}
+
+func TestTypedResultRetryStopReason(t *testing.T) {
+	errDeclined := errors.New("card declined")
+	wp := NewTyped[typedTestOptions, string](freshCtx(), defaultWorkers)
+	r := wp.SubmitWaitXT(TypedJob[typedTestOptions, string]{
+		Job: Job{Name: "permanent", Retry: 3},
+		Task: func(o typedTestOptions) TypedResult[string] {
+			return TypedResult[string]{Error: Permanent(errDeclined)}
+		},
+	})
+	wp.StopWaitXT()
+
+	if r.RetryStopReason() != RetryPermanent {
+		t.Fatalf("Expected stop reason '%s' : got '%s'", RetryPermanent, r.RetryStopReason())
+	}
+}
+
+func TestTypedResultAttempts(t *testing.T) {
+	var attempts int32
+	wp := NewTyped[typedTestOptions, string](freshCtx(), defaultWorkers)
+	r := wp.SubmitWaitXT(TypedJob[typedTestOptions, string]{
+		Job: Job{Name: "flaky", Retry: 3, RetryPolicy: ConstantRetry{Interval: time.Millisecond}},
+		Task: func(o typedTestOptions) TypedResult[string] {
+			if atomic.AddInt32(&attempts, 1) < 3 {
+				return TypedResult[string]{Error: errors.New("flaky")}
+			}
+			return TypedResult[string]{Data: "ok"}
+		},
+	})
+	wp.StopWaitXT()
+
+	if n := len(r.Attempts()); n != 3 {
+		t.Fatalf("Expected 3 attempts : got %d", n)
+	}
+	if r.Attempts()[2].Error != nil {
+		t.Fatalf("Expected last attempt to succeed : got %v", r.Attempts()[2].Error)
+	}
+}
+
+func TestTypedFutureTimeoutHasZeroData(t *testing.T) {
+	wp := NewTyped[struct{}, int](freshCtx(), defaultWorkers)
+	ctx, done := context.WithTimeout(freshCtx(), time.Millisecond)
+	defer done()
+
+	f := wp.SubmitFutureXT(TypedJob[struct{}, int]{
+		Job: Job{Name: "timeout", Context: ctx},
+		Task: func(struct{}) TypedResult[int] {
+			time.Sleep(time.Second)
+			return TypedResult[int]{Data: 42}
+		},
+	})
+
+	r, err := f.Wait(freshCtx())
+	if err != nil {
+		t.Fatalf("Expected no error from Wait : got %s", err)
+	}
+	if r.Error != context.DeadlineExceeded || r.Data != 0 {
+		t.Fatalf("Expected %s with zero data : got %v with %d", context.DeadlineExceeded, r.Error, r.Data)
+	}
+	wp.StopWaitXT()
+}
+
+func TestTaskCtxExitsOnTimeout(t *testing.T) {
+	wp := makeDefaultWp()
+	ctx, done := context.WithTimeout(freshCtx(), time.Millisecond*5)
+	defer done()
+	exited := make(chan error, 1)
+
+	wp.SubmitXT(Job{
+		Name:    "cooperative",
+		Context: ctx,
+		TaskCtx: func(ctx context.Context, o Options) Result {
+			select {
+			case <-ctx.Done():
+				exited <- ctx.Err()
+				return Result{Error: ctx.Err()}
+			case <-time.After(time.Second * 10):
+				exited <- nil
+				return Result{Data: "should not happen"}
+			}
+		},
+	})
+
+	results := wp.StopWaitXT()
+	if results[0].Error != context.DeadlineExceeded {
+		t.Fatalf("Expected error %s : got %v", context.DeadlineExceeded, results[0].Error)
+	}
+	select {
+	case err := <-exited:
+		if err != context.DeadlineExceeded {
+			t.Fatalf("Expected task to observe %s : got %v", context.DeadlineExceeded, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected cooperative task to exit after timeout")
+	}
+}
+
+func TestTaskCtxExitsOnCancel(t *testing.T) {
+	wp := makeDefaultWp()
+	started, exited := make(chan struct{}), make(chan struct{})
+
+	f := wp.SubmitFutureXT(Job{
+		Name: "cooperative",
+		TaskCtx: func(ctx context.Context, o Options) Result {
+			close(started)
+			<-ctx.Done()
+			close(exited)
+			return Result{Error: ctx.Err()}
+		},
+	})
+
+	<-started
+	f.Cancel()
+	select {
+	case <-exited:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected cooperative task to exit after Cancel")
+	}
+	wp.StopWaitXT()
+}
+
+func TestTaskCtxReceivesOptions(t *testing.T) {
+	wp := NewWithOptions(freshCtx(), defaultWorkers, Options{"var": "value"})
+	wp.SubmitXT(Job{
+		Name: "options",
+		TaskCtx: func(ctx context.Context, o Options) Result {
+			return Result{Data: o["var"]}
+		},
+	})
+	results := wp.StopWaitXT()
+	if results[0].Data != "value" {
+		t.Fatalf("Expected 'value' : got %v", results[0].Data)
+	}
+}
+
+func TestPanicIsRecovered(t *testing.T) {
+	wp := makeDefaultWp()
+	wp.SubmitXT(Job{
+		Name: "panics",
+		Task: func(o Options) Result {
+			panic("boom")
+		},
+	})
+	wp.SubmitXT(Job{
+		Name: "fine",
+		Task: func(o Options) Result {
+			return Result{Data: "fine"}
+		},
+	})
+
+	results := wp.StopWaitXT()
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 results : got %d", len(results))
+	}
+	for _, r := range results {
+		if r.Name() != "panics" {
+			continue
+		}
+		var pe *PanicError
+		if !errors.As(r.Error, &pe) {
+			t.Fatalf("Expected *PanicError : got %T", r.Error)
+		}
+		if pe.Value != "boom" || len(pe.Stack) == 0 {
+			t.Fatalf("Expected panic value 'boom' with a stack : got %v with stack len %d", pe.Value, len(pe.Stack))
+		}
+	}
+}
+
+func TestPanicNilIsRecovered(t *testing.T) {
+	wp := makeDefaultWp()
+	r := wp.SubmitWaitXT(Job{
+		Name: "panics with nil",
+		Task: func(o Options) Result {
+			panic(nil)
+		},
+	})
+	wp.StopWaitXT()
+
+	var pe *PanicError
+	if !errors.As(r.Error, &pe) {
+		t.Fatalf("Expected *PanicError : got %T", r.Error)
+	}
+}
+
+func TestPanicIsNotRetriedByDefault(t *testing.T) {
+	var attempts int32
+	wp := makeDefaultWp()
+	wp.SubmitXT(Job{
+		Name:  "panics",
+		Retry: 3,
+		Task: func(o Options) Result {
+			atomic.AddInt32(&attempts, 1)
+			panic("boom")
+		},
+	})
+
+	results := wp.StopWaitXT()
+	if _, ok := results[0].Error.(*PanicError); !ok {
+		t.Fatalf("Expected *PanicError : got %T", results[0].Error)
+	}
+	if n := atomic.LoadInt32(&attempts); n != 1 {
+		t.Fatalf("Expected 1 attempt : got %d", n)
+	}
+}
+
+func TestRetryPanics(t *testing.T) {
+	var attempts int32
+	wp := makeDefaultWp()
+	wp.SubmitXT(Job{
+		Name:        "panics once",
+		Retry:       1,
+		RetryPanics: true,
+		Task: func(o Options) Result {
+			if atomic.AddInt32(&attempts, 1) == 1 {
+				panic("boom")
+			}
+			return Result{Data: "recovered"}
+		},
+	})
+
+	results := wp.StopWaitXT()
+	if results[0].Error != nil || results[0].Data != "recovered" {
+		t.Fatalf("Expected job to succeed on retry : got %v", results[0].Error)
+	}
+	if n := atomic.LoadInt32(&attempts); n != 2 {
+		t.Fatalf("Expected 2 attempts : got %d", n)
+	}
+}
+
+func TestRetryPolicyPerJob(t *testing.T) {
+	var attempts int32
+	wp := makeDefaultWp()
+	start := time.Now()
+	wp.SubmitXT(Job{
+		Name:        "constant",
+		Retry:       5,
+		RetryPolicy: ConstantRetry{Interval: time.Millisecond},
+		Task: func(o Options) Result {
+			atomic.AddInt32(&attempts, 1)
+			return Result{Error: errors.New("fail")}
+		},
+	})
+	wp.StopWaitXT()
+
+	if n := atomic.LoadInt32(&attempts); n != 6 {
+		t.Fatalf("Expected 6 attempts : got %d", n)
+	}
+	if took := time.Since(start); took > time.Millisecond*250 {
+		t.Fatalf("Expected constant 1ms retries to finish quickly : took %s", took)
+	}
+}
+
+func TestRetryPolicyPoolDefault(t *testing.T) {
+	var attempts int32
+	wp := makeDefaultWp()
+	wp.SetRetryPolicy(RetryPolicyFunc(func() backoff.BackOff { return &backoff.ZeroBackOff{} }))
+	wp.SubmitXT(Job{
+		Name:  "pool policy",
+		Retry: 3,
+		Task: func(o Options) Result {
+			atomic.AddInt32(&attempts, 1)
+			return Result{Error: errors.New("fail")}
+		},
+	})
+	wp.StopWaitXT()
+
+	if n := atomic.LoadInt32(&attempts); n != 4 {
+		t.Fatalf("Expected 4 attempts : got %d", n)
+	}
+}
+
+func TestLinearRetry(t *testing.T) {
+	b := LinearRetry{Initial: time.Second, Increment: time.Second, Max: time.Second * 3}.BackOff()
+	expected := []time.Duration{time.Second, time.Second * 2, time.Second * 3, time.Second * 3}
+	for i, e := range expected {
+		if next := b.NextBackOff(); next != e {
+			t.Fatalf("Expected backoff %d to be %s : got %s", i, e, next)
+		}
+	}
+	b.Reset()
+	if next := b.NextBackOff(); next != time.Second {
+		t.Fatalf("Expected backoff after Reset to be %s : got %s", time.Second, next)
+	}
+}
+
+func TestDecorrelatedJitterRetry(t *testing.T) {
+	base, max := time.Millisecond*10, time.Millisecond*100
+	b := DecorrelatedJitterRetry{Base: base, Max: max}.BackOff()
+	prev := base
+	for i := 0; i < 100; i++ {
+		next := b.NextBackOff()
+		if next < base || next > max || next > prev*3 {
+			t.Fatalf("Expected backoff between %s and min(%s, %s) : got %s", base, max, prev*3, next)
+		}
+		prev = next
+	}
+}
+
+func TestDecorrelatedJitterRetryDefaultsBase(t *testing.T) {
+	b := DecorrelatedJitterRetry{}.BackOff()
+	for i := 0; i < 10; i++ {
+		if next := b.NextBackOff(); next < backoff.DefaultInitialInterval {
+			t.Fatalf("Expected backoff of at least %s : got %s", backoff.DefaultInitialInterval, next)
+		}
+	}
+}
+
+func TestExponentialRetry(t *testing.T) {
+	b := ExponentialRetry{InitialInterval: time.Millisecond, Multiplier: 2, RandomizationFactor: -1, MaxInterval: time.Millisecond * 4}.BackOff()
+	expected := []time.Duration{time.Millisecond, time.Millisecond * 2, time.Millisecond * 4, time.Millisecond * 4}
+	for i, e := range expected {
+		if next := b.NextBackOff(); next != e {
+			t.Fatalf("Expected backoff %d to be %s : got %s", i, e, next)
+		}
+	}
+
+	eb := ExponentialRetry{MaxElapsedTime: -1}.BackOff().(*backoff.ExponentialBackOff)
+	if eb.MaxElapsedTime != 0 {
+		t.Fatalf("Expected no max elapsed time : got %s", eb.MaxElapsedTime)
+	}
+}
+
+func TestRetryIf(t *testing.T) {
+	var attempts int32
+	errValidation := errors.New("validation failed")
+	wp := makeDefaultWp()
+	wp.SubmitXT(Job{
+		Name:        "not retryable",
+		Retry:       5,
+		RetryPolicy: ConstantRetry{Interval: time.Millisecond},
+		RetryIf:     func(err error) bool { return err != errValidation },
+		Task: func(o Options) Result {
+			atomic.AddInt32(&attempts, 1)
+			return Result{Error: errValidation}
+		},
+	})
+
+	results := wp.StopWaitXT()
+	if n := atomic.LoadInt32(&attempts); n != 1 {
+		t.Fatalf("Expected 1 attempt : got %d", n)
+	}
+	if results[0].Error != errValidation {
+		t.Fatalf("Expected error %s : got %v", errValidation, results[0].Error)
+	}
+	if results[0].RetryStopReason() != RetryNotRetryable {
+		t.Fatalf("Expected stop reason '%s' : got '%s'", RetryNotRetryable, results[0].RetryStopReason())
+	}
+}
+
+func TestRetryIfPoolDefault(t *testing.T) {
+	var attempts int32
+	wp := makeDefaultWp()
+	wp.SetRetryIf(func(err error) bool { return false })
+	wp.SubmitXT(Job{
+		Name:  "not retryable",
+		Retry: 5,
+		Task: func(o Options) Result {
+			atomic.AddInt32(&attempts, 1)
+			return Result{Error: errors.New("fail")}
+		},
+	})
+	wp.StopWaitXT()
+	if n := atomic.LoadInt32(&attempts); n != 1 {
+		t.Fatalf("Expected 1 attempt : got %d", n)
+	}
+}
+
+func TestPermanentStopsRetry(t *testing.T) {
+	errPermanent := errors.New("will never work")
+	for _, wrap := range []func(error) error{
+		Permanent,
+		func(err error) error { return backoff.Permanent(err) },
+	} {
+		var attempts int32
+		wp := makeDefaultWp()
+		wp.SubmitXT(Job{
+			Name:        "permanent",
+			Retry:       5,
+			RetryPolicy: ConstantRetry{Interval: time.Millisecond},
+			Task: func(o Options) Result {
+				atomic.AddInt32(&attempts, 1)
+				return Result{Error: wrap(errPermanent)}
+			},
+		})
+
+		results := wp.StopWaitXT()
+		if n := atomic.LoadInt32(&attempts); n != 1 {
+			t.Fatalf("Expected 1 attempt : got %d", n)
+		}
+		if results[0].Error != errPermanent {
+			t.Fatalf("Expected unwrapped error %s : got %v", errPermanent, results[0].Error)
+		}
+		if results[0].RetryStopReason() != RetryPermanent {
+			t.Fatalf("Expected stop reason '%s' : got '%s'", RetryPermanent, results[0].RetryStopReason())
+		}
+	}
+}
+
+func TestRetryStopReasons(t *testing.T) {
+	wp := makeDefaultWp()
+	wp.SubmitXT(Job{
+		Name:        "exhausted",
+		Retry:       2,
+		RetryPolicy: ConstantRetry{Interval: time.Millisecond},
+		Task: func(o Options) Result {
+			return Result{Error: errors.New("fail")}
+		},
+	})
+	wp.SubmitXT(Job{
+		Name:        "policy stopped",
+		Retry:       5,
+		RetryPolicy: RetryPolicyFunc(func() backoff.BackOff { return &backoff.StopBackOff{} }),
+		Task: func(o Options) Result {
+			return Result{Error: errors.New("fail")}
+		},
+	})
+	wp.SubmitXT(Job{
+		Name: "succeeded",
+		Task: func(o Options) Result {
+			return Result{}
+		},
+	})
+
+	expected := map[string]RetryStopReason{
+		"exhausted":      RetryExhausted,
+		"policy stopped": RetryPolicyStopped,
+		"succeeded":      RetryNotStopped,
+	}
+	for _, r := range wp.StopWaitXT() {
+		if r.RetryStopReason() != expected[r.Name()] {
+			t.Fatalf("Expected '%s' stop reason '%s' : got '%s'", r.Name(), expected[r.Name()], r.RetryStopReason())
+		}
+	}
+}
+
+func TestAttempts(t *testing.T) {
+	var calls int32
+	wp := makeDefaultWp()
+	wp.SubmitXT(Job{
+		Name:        "succeeds on third attempt",
+		Retry:       5,
+		RetryPolicy: ConstantRetry{Interval: time.Millisecond},
+		Task: func(o Options) Result {
+			if atomic.AddInt32(&calls, 1) < 3 {
+				return Result{Error: fmt.Errorf("attempt %d failed", atomic.LoadInt32(&calls))}
+			}
+			return Result{Data: "ok"}
+		},
+	})
+
+	results := wp.StopWaitXT()
+	attempts := results[0].Attempts()
+	if len(attempts) != 3 {
+		t.Fatalf("Expected 3 attempts : got %d", len(attempts))
+	}
+	for i, a := range attempts {
+		if a.Number != i+1 {
+			t.Fatalf("Expected attempt number %d : got %d", i+1, a.Number)
+		}
+		if a.Start.IsZero() || a.End.Before(a.Start) {
+			t.Fatalf("Expected attempt %d to have start and end times : got %s - %s", a.Number, a.Start, a.End)
+		}
+		if i > 0 && a.Start.Before(attempts[i-1].End) {
+			t.Fatalf("Expected attempt %d to start after attempt %d ended", a.Number, attempts[i-1].Number)
+		}
+	}
+	if attempts[0].Error == nil || attempts[1].Error == nil || attempts[2].Error != nil {
+		t.Fatalf("Expected first 2 attempts to fail and the last to succeed : got %v", attempts)
+	}
+}
+
+func TestAttemptsOnTimeout(t *testing.T) {
+	wp := makeDefaultWp()
+	ctx, done := context.WithTimeout(freshCtx(), time.Millisecond*20)
+	defer done()
+	wp.SubmitXT(Job{
+		Name:        "times out while retrying",
+		Context:     ctx,
+		Retry:       100,
+		RetryPolicy: ConstantRetry{Interval: time.Millisecond},
+		Task: func(o Options) Result {
+			time.Sleep(time.Millisecond * 5)
+			return Result{Error: errors.New("fail")}
+		},
+	})
+
+	results := wp.StopWaitXT()
+	if results[0].Error != context.DeadlineExceeded {
+		t.Fatalf("Expected error %s : got %v", context.DeadlineExceeded, results[0].Error)
+	}
+	if len(results[0].Attempts()) == 0 {
+		t.Fatalf("Expected attempts to be recorded for a job that timed out")
+	}
+}
+
+func TestAttemptTimeoutRetriesHungAttempt(t *testing.T) {
+	var calls int32
+	wp := makeDefaultWp()
+	start := time.Now()
+	wp.SubmitXT(Job{
+		Name:           "first attempt hangs",
+		Retry:          1,
+		RetryPolicy:    ConstantRetry{Interval: time.Millisecond},
+		AttemptTimeout: time.Millisecond * 10,
+		Task: func(o Options) Result {
+			if atomic.AddInt32(&calls, 1) == 1 {
+				time.Sleep(time.Second * 5)
+			}
+			return Result{Data: "ok"}
+		},
+	})
+
+	results := wp.StopWaitXT()
+	if took := time.Since(start); took > time.Second {
+		t.Fatalf("Expected hung attempt to be abandoned : took %s", took)
+	}
+	if results[0].Error != nil || results[0].Data != "ok" {
+		t.Fatalf("Expected second attempt to succeed : got %v", results[0].Error)
+	}
+	attempts := results[0].Attempts()
+	if len(attempts) != 2 || attempts[0].Error != ErrAttemptTimeout {
+		t.Fatalf("Expected first of 2 attempts to fail with %s : got %v", ErrAttemptTimeout, attempts)
+	}
+}
+
+func TestAttemptTimeoutCancelsTaskCtx(t *testing.T) {
+	wp := makeDefaultWp()
+	observed := make(chan error, 1)
+	wp.SubmitXT(Job{
+		Name:           "cooperative attempt",
+		AttemptTimeout: time.Millisecond * 5,
+		TaskCtx: func(ctx context.Context, o Options) Result {
+			<-ctx.Done()
+			observed <- ctx.Err()
+			return Result{Error: ctx.Err()}
+		},
+	})
+
+	results := wp.StopWaitXT()
+	if results[0].Error != ErrAttemptTimeout {
+		t.Fatalf("Expected error %s : got %v", ErrAttemptTimeout, results[0].Error)
+	}
+	if err := <-observed; err != context.DeadlineExceeded {
+		t.Fatalf("Expected task to observe %s : got %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestJobContextBoundsAttemptTimeouts(t *testing.T) {
+	timeout := time.Millisecond * 30
+	wp := makeDefaultWp()
+	ctx, done := context.WithTimeout(freshCtx(), timeout)
+	defer done()
+	wp.SubmitXT(Job{
+		Name:           "always hangs",
+		Context:        ctx,
+		Retry:          100,
+		RetryPolicy:    ConstantRetry{Interval: time.Millisecond},
+		AttemptTimeout: time.Millisecond * 10,
+		Task: func(o Options) Result {
+			time.Sleep(time.Second)
+			return Result{}
+		},
+	})
+
+	results := wp.StopWaitXT()
+	if results[0].Error != context.DeadlineExceeded {
+		t.Fatalf("Expected error %s : got %v", context.DeadlineExceeded, results[0].Error)
+	}
+	if results[0].Duration() > timeout*2 {
+		t.Fatalf("Expected job to stop at its deadline of %s : took %s", timeout, results[0].Duration())
+	}
+}
+
+// concurrencyTracker records how many tasks run at once
+type concurrencyTracker struct {
+	current int32
+	max     int32
+}
+
+func (c *concurrencyTracker) start() int32 {
+	n := atomic.AddInt32(&c.current, 1)
+	for {
+		max := atomic.LoadInt32(&c.max)
+		if n <= max || atomic.CompareAndSwapInt32(&c.max, max, n) {
+			return n
+		}
+	}
+}
+
+func (c *concurrencyTracker) end() {
+	atomic.AddInt32(&c.current, -1)
+}
+
+func TestResizeGrow(t *testing.T) {
+	numJobs, workers, grownTo := 60, 2, 6
+	tracker := &concurrencyTracker{}
+	wp := New(freshCtx(), workers)
+	wp.Resize(grownTo)
+	if wp.Size() != grownTo {
+		t.Fatalf("Expected Size() %d : got %d", grownTo, wp.Size())
+	}
+
+	for i := 0; i < numJobs; i++ {
+		wp.SubmitXT(Job{
+			Name: fmt.Sprintf("Job %d", i),
+			Task: func(o Options) Result {
+				defer tracker.end()
+				tracker.start()
+				time.Sleep(time.Millisecond * 5)
+				return Result{}
+			},
+		})
+	}
+
+	results := wp.StopWaitXT()
+	if len(results) != numJobs {
+		t.Fatalf("Expected %d results : got %d", numJobs, len(results))
+	}
+	max := int(atomic.LoadInt32(&tracker.max))
+	if max <= workers || max > grownTo {
+		t.Fatalf("Expected max concurrency above %d and at most %d : got %d", workers, grownTo, max)
+	}
+}
+
+func TestResizeShrink(t *testing.T) {
+	numJobs, workers, shrunkTo := 40, 8, 2
+	tracker := &concurrencyTracker{}
+	var resized int32
+	var exceeded int32
+	release := make(chan struct{})
+	wp := New(freshCtx(), workers)
+
+	for i := 0; i < numJobs; i++ {
+		wp.SubmitXT(Job{
+			Name: fmt.Sprintf("Job %d", i),
+			Task: func(o Options) Result {
+				defer tracker.end()
+				n := tracker.start()
+				if atomic.LoadInt32(&resized) == 1 && n > int32(shrunkTo) {
+					atomic.StoreInt32(&exceeded, n)
+				}
+				<-release
+				return Result{}
+			},
+		})
+	}
+
+	// Wait for the first workers to pick up jobs, then shrink while they are in flight
+	for atomic.LoadInt32(&tracker.current) != int32(workers) {
+		time.Sleep(time.Millisecond)
+	}
+	atomic.StoreInt32(&resized, 1)
+	wp.Resize(shrunkTo)
+	close(release)
+
+	results := wp.StopWaitXT()
+	if len(results) != numJobs {
+		t.Fatalf("Expected %d results : got %d", numJobs, len(results))
+	}
+	if n := atomic.LoadInt32(&exceeded); n != 0 {
+		t.Fatalf("Expected at most %d jobs to start at once after shrinking : got %d", shrunkTo, n)
+	}
+}
+
+func TestResizeWhileRunning_Special(t *testing.T) {
+	numJobs := 200
+	tracker := &concurrencyTracker{}
+	wp := New(freshCtx(), 4)
+	sizes := []int{1, 8, 3, 12, 2, 4}
+
+	for i := 0; i < numJobs; i++ {
+		if i%(numJobs/len(sizes)) == 0 {
+			wp.Resize(sizes[(i/(numJobs/len(sizes)))%len(sizes)])
+		}
+		wp.SubmitXT(Job{
+			Name: fmt.Sprintf("Job %d", i),
+			Task: func(o Options) Result {
+				defer tracker.end()
+				tracker.start()
+				time.Sleep(time.Microsecond * 100)
+				return Result{}
+			},
+		})
+	}
+
+	results := wp.StopWaitXT()
+	if len(results) != numJobs {
+		t.Fatalf("Expected %d results : got %d", numJobs, len(results))
+	}
+	if max := atomic.LoadInt32(&tracker.max); max > 12 {
+		t.Fatalf("Expected max concurrency of at most 12 : got %d", max)
+	}
+}
+
+// expectSubmitRuns fails the test if a task submitted with Submit does not run
+func expectSubmitRuns(t *testing.T, wp *WorkerPoolXT) {
+	ran := make(chan struct{})
+	wp.Submit(func() { close(ran) })
+	select {
+	case <-ran:
+	case <-time.After(time.Second * 5):
+		t.Fatalf("Expected task submitted with Submit to run")
+	}
+}
+
+func TestResizeDoesNotHoldWorkers(t *testing.T) {
+	started := make(chan struct{}, 8)
+	release := make(chan struct{})
+	wp := New(freshCtx(), 4)
+	wp.Resize(1)
+	for i := 0; i < 8; i++ {
+		wp.SubmitXT(Job{
+			Name: fmt.Sprintf("Job %d", i),
+			Task: func(o Options) Result {
+				started <- struct{}{}
+				<-release
+				return Result{}
+			},
+		})
+	}
+	<-started
+
+	expectSubmitRuns(t, wp)
+	close(release)
+	if n := len(wp.StopWaitXT()); n != 8 {
+		t.Fatalf("Expected 8 results : got %d", n)
+	}
+}
+
+// fakeClock is a Clock that only moves when told to
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock *fakeClock
+	c     chan time.Time
+	at    time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{clock: c, c: make(chan time.Time, 1), at: c.now.Add(d)}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Advance moves the clock forward, firing any timers that are due
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	live := c.timers[:0]
+	for _, t := range c.timers {
+		if t.at.After(c.now) {
+			live = append(live, t)
+			continue
+		}
+		t.c <- c.now
+	}
+	c.timers = live
+}
+
+// waitForTimers blocks until at least n timers are waiting to fire
+func (c *fakeClock) waitForTimers(t *testing.T, n int) {
+	deadline := time.Now().Add(time.Second * 5)
+	for time.Now().Before(deadline) {
+		c.mu.Lock()
+		count := len(c.timers)
+		c.mu.Unlock()
+		if count >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d timers", n)
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	for i, tt := range t.clock.timers {
+		if tt == t {
+			t.clock.timers = append(t.clock.timers[:i], t.clock.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// waitForSize blocks until the pool has the expected Size()
+func waitForSize(t *testing.T, wp *WorkerPoolXT, expected int) {
+	deadline := time.Now().Add(time.Second * 5)
+	for wp.Size() != expected {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected Size() %d : got %d", expected, wp.Size())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestAutoscale(t *testing.T) {
+	clock := newFakeClock()
+	release := make(chan struct{})
+	wp := New(freshCtx(), 1)
+	wp.SetClock(clock)
+	wp.Autoscale(AutoscaleConfig{Min: 1, Max: 3, Interval: time.Second})
+
+	for i := 0; i < 10; i++ {
+		wp.SubmitXT(Job{
+			Name: fmt.Sprintf("Job %d", i),
+			Task: func(o Options) Result {
+				<-release
+				return Result{}
+			},
+		})
+	}
+
+	// Jobs are waiting so every tick grows the pool, up to Max
+	for _, expected := range []int{2, 3, 3} {
+		clock.waitForTimers(t, 1)
+		clock.Advance(time.Second)
+		waitForSize(t, wp, expected)
+	}
+
+	close(release)
+	for {
+		if waiting, running := wp.sched.counts(); waiting == 0 && running == 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Nothing is running so every tick shrinks the pool, down to Min
+	for _, expected := range []int{2, 1, 1} {
+		clock.waitForTimers(t, 1)
+		clock.Advance(time.Second)
+		waitForSize(t, wp, expected)
+	}
+
+	if results := wp.StopWaitXT(); len(results) != 10 {
+		t.Fatalf("Expected 10 results : got %d", len(results))
+	}
+}
+
+func TestAutoscaleCustomStrategy(t *testing.T) {
+	clock := newFakeClock()
+	wp := New(freshCtx(), 1)
+	wp.Autoscale(AutoscaleConfig{
+		Min:      2,
+		Max:      10,
+		Interval: time.Second,
+		Clock:    clock,
+		Strategy: ScalingStrategyFunc(func(s ScalingStats) int { return 100 }),
+	})
+	if wp.Size() != 2 {
+		t.Fatalf("Expected Autoscale to resize to Min %d : got %d", 2, wp.Size())
+	}
+
+	clock.waitForTimers(t, 1)
+	clock.Advance(time.Second)
+	waitForSize(t, wp, 10)
+	wp.StopWaitXT()
+}
+
+func TestScalingStrategies(t *testing.T) {
+	busy := ScalingStats{Size: 4, Waiting: 10, Running: 4}
+	tests := []struct {
+		name     string
+		strategy ScalingStrategy
+		stats    ScalingStats
+		expected int
+	}{
+		{"queue depth grows", QueueDepthScaling{Step: 2}, busy, 6},
+		{"queue depth shrinks when idle", QueueDepthScaling{}, ScalingStats{Size: 4, Running: 1}, 3},
+		{"queue depth holds", QueueDepthScaling{}, ScalingStats{Size: 4, Running: 3}, 4},
+		{"latency shrinks when slow", LatencyScaling{Target: time.Second}, ScalingStats{Size: 4, Waiting: 10, Completed: 1, AvgDuration: time.Second * 2}, 3},
+		{"latency defers when fast", LatencyScaling{Target: time.Second}, ScalingStats{Size: 4, Waiting: 10, Completed: 1, AvgDuration: time.Millisecond}, 5},
+		{"error rate shrinks when failing", ErrorRateScaling{MaxErrorRate: 0.1}, ScalingStats{Size: 4, Waiting: 10, Completed: 10, Failed: 5}, 3},
+		{"error rate defers when healthy", ErrorRateScaling{MaxErrorRate: 0.5, Next: QueueDepthScaling{Step: 3}}, ScalingStats{Size: 4, Waiting: 10, Completed: 10, Failed: 1}, 7},
+	}
+	for _, tt := range tests {
+		if got := tt.strategy.Scale(tt.stats); got != tt.expected {
+			t.Fatalf("%s : expected %d got %d", tt.name, tt.expected, got)
+		}
+	}
+}
+
+// submitOrderJobs submits a job that blocks the only worker, then jobs which
+// record the order they ran in. Returns a func that releases the blocker.
+func submitOrderJobs(wp *WorkerPoolXT, order *[]string, mu *sync.Mutex, jobs []Job) func() {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	wp.SubmitXT(Job{
+		Name: "blocker",
+		Task: func(o Options) Result {
+			close(started)
+			<-release
+			return Result{}
+		},
+	})
+	<-started
+	for _, j := range jobs {
+		name := j.Name
+		j.Task = func(o Options) Result {
+			mu.Lock()
+			defer mu.Unlock()
+			*order = append(*order, name)
+			return Result{}
+		}
+		wp.SubmitXT(j)
+	}
+	return func() { close(release) }
+}
+
+func TestPriorityOrdering(t *testing.T) {
+	var order []string
+	var mu sync.Mutex
+	wp := New(freshCtx(), 1)
+	release := submitOrderJobs(wp, &order, &mu, []Job{
+		{Name: "low", Priority: PriorityLow},
+		{Name: "normal 1"},
+		{Name: "high 1", Priority: PriorityHigh},
+		{Name: "normal 2", Priority: PriorityNormal},
+		{Name: "high 2", Priority: PriorityHigh},
+		{Name: "urgent", Priority: 100},
+	})
+	release()
+	wp.StopWaitXT()
+
+	expected := []string{"urgent", "high 1", "high 2", "normal 1", "normal 2", "low"}
+	if fmt.Sprint(order) != fmt.Sprint(expected) {
+		t.Fatalf("Expected order %v : got %v", expected, order)
+	}
+}
+
+func TestPriorityAging(t *testing.T) {
+	var order []string
+	var mu sync.Mutex
+	clock := newFakeClock()
+	wp := New(freshCtx(), 1)
+	wp.SetClock(clock)
+	wp.SetPriorityAging(time.Millisecond)
+
+	release := submitOrderJobs(wp, &order, &mu, []Job{{Name: "old low", Priority: PriorityLow}})
+	// By now "old low" has gained far more than the 20 priority "new high" is ahead by
+	clock.Advance(time.Millisecond * 50)
+	wp.SubmitXT(Job{
+		Name:     "new high",
+		Priority: PriorityHigh,
+		Task: func(o Options) Result {
+			mu.Lock()
+			defer mu.Unlock()
+			order = append(order, "new high")
+			return Result{}
+		},
+	})
+	release()
+	wp.StopWaitXT()
+
+	expected := []string{"old low", "new high"}
+	if fmt.Sprint(order) != fmt.Sprint(expected) {
+		t.Fatalf("Expected order %v : got %v", expected, order)
+	}
+}
+
+// sumUpstream returns a task that adds `add` to the Data of every upstream step
+func sumUpstream(add int) func(context.Context, Options) Result {
+	return func(ctx context.Context, o Options) Result {
+		sum := add
+		for _, r := range UpstreamResults(ctx) {
+			sum += r.Data.(int)
+		}
+		return Result{Data: sum}
+	}
+}
+
+func TestWorkflowDiamond(t *testing.T) {
+	wp := makeDefaultWp()
+	results, err := wp.RunWorkflow(freshCtx(), Workflow{
+		Steps: []Step{
+			{ID: "d", DependsOn: []string{"b", "c"}, Job: Job{TaskCtx: sumUpstream(1000)}},
+			{ID: "b", DependsOn: []string{"a"}, Job: Job{TaskCtx: sumUpstream(10)}},
+			{ID: "a", Job: Job{TaskCtx: sumUpstream(1)}},
+			{ID: "c", DependsOn: []string{"a"}, Job: Job{TaskCtx: sumUpstream(100)}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error : got %s", err)
+	}
+
+	// a=1, b=1+10, c=1+100, d=11+101+1000
+	expected := map[string]int{"a": 1, "b": 11, "c": 101, "d": 1112}
+	for id, data := range expected {
+		if results[id].Data != data {
+			t.Fatalf("Expected step '%s' data %d : got %v", id, data, results[id].Data)
+		}
+		if r := results[id]; r.Name() != id {
+			t.Fatalf("Expected step '%s' to be named after its ID : got '%s'", id, r.Name())
+		}
+	}
+	if n := len(wp.StopWaitXT()); n != 4 {
+		t.Fatalf("Expected pool to collect 4 results : got %d", n)
+	}
+}
+
+func TestWorkflowValidation(t *testing.T) {
+	task := Job{Task: func(o Options) Result { return Result{} }}
+	tests := []struct {
+		name     string
+		steps    []Step
+		expected error
+	}{
+		{"cycle", []Step{{ID: "a", DependsOn: []string{"c"}, Job: task}, {ID: "b", DependsOn: []string{"a"}, Job: task}, {ID: "c", DependsOn: []string{"b"}, Job: task}}, ErrWorkflowCycle},
+		{"self", []Step{{ID: "a", DependsOn: []string{"a"}, Job: task}}, ErrWorkflowCycle},
+		{"unknown", []Step{{ID: "a", DependsOn: []string{"nope"}, Job: task}}, ErrUnknownDependency},
+		{"duplicate", []Step{{ID: "a", Job: task}, {ID: "a", Job: task}}, ErrDuplicateStep},
+	}
+
+	wp := makeDefaultWp()
+	for _, tt := range tests {
+		results, err := wp.RunWorkflow(freshCtx(), Workflow{Steps: tt.steps})
+		if !errors.Is(err, tt.expected) {
+			t.Fatalf("%s : expected error %s : got %v", tt.name, tt.expected, err)
+		}
+		if results != nil {
+			t.Fatalf("%s : expected nothing to run", tt.name)
+		}
+	}
+	if n := len(wp.StopWaitXT()); n != 0 {
+		t.Fatalf("Expected no jobs to run : got %d", n)
+	}
+}
+
+func TestWorkflowSkipDependents(t *testing.T) {
+	wp := makeDefaultWp()
+	fail := Job{Task: func(o Options) Result { return Result{Error: errors.New("fail")} }}
+	ok := Job{Task: func(o Options) Result { return Result{Data: "ok"} }}
+	results, _ := wp.RunWorkflow(freshCtx(), Workflow{
+		Steps: []Step{
+			{ID: "a", Job: fail},
+			{ID: "b", DependsOn: []string{"a"}, Job: ok},
+			{ID: "c", DependsOn: []string{"b"}, Job: ok},
+			{ID: "other", Job: ok},
+		},
+	})
+	wp.StopWaitXT()
+
+	if results["b"].Error != ErrUpstreamFailed || results["c"].Error != ErrUpstreamFailed {
+		t.Fatalf("Expected b and c to be skipped : got %v and %v", results["b"].Error, results["c"].Error)
+	}
+	if results["other"].Data != "ok" {
+		t.Fatalf("Expected independent step to run : got %v", results["other"].Error)
+	}
+}
+
+func TestWorkflowFailWorkflow(t *testing.T) {
+	wp := makeDefaultWp()
+	results, _ := wp.RunWorkflow(freshCtx(), Workflow{
+		OnFailure: FailWorkflow,
+		Steps: []Step{
+			{ID: "a", Job: Job{Task: func(o Options) Result {
+				time.Sleep(time.Millisecond * 5)
+				return Result{Error: errors.New("fail")}
+			}}},
+			{ID: "b", DependsOn: []string{"a"}, Job: Job{Task: func(o Options) Result { return Result{} }}},
+			{ID: "slow", Job: Job{TaskCtx: func(ctx context.Context, o Options) Result {
+				<-ctx.Done()
+				return Result{Error: ctx.Err()}
+			}}},
+			{ID: "after slow", DependsOn: []string{"slow"}, Job: Job{Task: func(o Options) Result { return Result{} }}},
+		},
+	})
+	wp.StopWaitXT()
+
+	if results["slow"].Error != context.Canceled {
+		t.Fatalf("Expected running step to be cancelled : got %v", results["slow"].Error)
+	}
+	if results["b"].Error != ErrWorkflowFailed || results["after slow"].Error != ErrWorkflowFailed {
+		t.Fatalf("Expected steps that never started to fail : got %v and %v", results["b"].Error, results["after slow"].Error)
+	}
+}
+
+func TestWorkflowRunDependents(t *testing.T) {
+	wp := makeDefaultWp()
+	errUpstream := errors.New("fail")
+	results, _ := wp.RunWorkflow(freshCtx(), Workflow{
+		OnFailure: RunDependents,
+		Steps: []Step{
+			{ID: "a", Job: Job{Task: func(o Options) Result { return Result{Error: errUpstream} }}},
+			{ID: "b", DependsOn: []string{"a"}, Job: Job{TaskCtx: func(ctx context.Context, o Options) Result {
+				return Result{Data: UpstreamResults(ctx)["a"].Error}
+			}}},
+		},
+	})
+	wp.StopWaitXT()
+
+	if results["b"].Data != errUpstream {
+		t.Fatalf("Expected dependent to run and see upstream error : got %v", results["b"].Data)
+	}
+}
+
+func TestWorkflowMaxParallel(t *testing.T) {
+	tracker := &concurrencyTracker{}
+	var steps []Step
+	for i := 0; i < 8; i++ {
+		steps = append(steps, Step{ID: fmt.Sprintf("%d", i), Job: Job{Task: func(o Options) Result {
+			defer tracker.end()
+			tracker.start()
+			time.Sleep(time.Millisecond * 5)
+			return Result{}
+		}}})
+	}
+
+	wp := makeDefaultWp()
+	results, _ := wp.RunWorkflow(freshCtx(), Workflow{Steps: steps, MaxParallel: 2})
+	wp.StopWaitXT()
+
+	if len(results) != 8 {
+		t.Fatalf("Expected 8 results : got %d", len(results))
+	}
+	if max := atomic.LoadInt32(&tracker.max); max > 2 {
+		t.Fatalf("Expected at most 2 steps at once : got %d", max)
+	}
+}
+
+func TestWorkflowContextCancelled(t *testing.T) {
+	wp := makeDefaultWp()
+	ctx, cancel := context.WithCancel(freshCtx())
+	started := make(chan struct{})
+	go func() {
+		<-started
+		cancel()
+	}()
+
+	results, _ := wp.RunWorkflow(ctx, Workflow{
+		Steps: []Step{
+			{ID: "a", Job: Job{TaskCtx: func(ctx context.Context, o Options) Result {
+				close(started)
+				<-ctx.Done()
+				return Result{Error: ctx.Err()}
+			}}},
+			{ID: "b", DependsOn: []string{"a"}, Job: Job{Task: func(o Options) Result { return Result{} }}},
+		},
+		OnFailure: RunDependents,
+	})
+	wp.StopWaitXT()
+
+	if results["a"].Error != context.Canceled || results["b"].Error != context.Canceled {
+		t.Fatalf("Expected every step to be cancelled : got %v and %v", results["a"].Error, results["b"].Error)
+	}
+}
+
+func TestSubmitAfter(t *testing.T) {
+	clock := newFakeClock()
+	var ran int32
+	wp := makeDefaultWp()
+	wp.SetClock(clock)
+	f := wp.SubmitAfter(time.Second*30, Job{
+		Name: "later",
+		Task: func(o Options) Result {
+			atomic.StoreInt32(&ran, 1)
+			return Result{Data: "ran"}
+		},
+	})
+
+	clock.waitForTimers(t, 1)
+	clock.Advance(time.Second * 29)
+	time.Sleep(time.Millisecond * 10)
+	if atomic.LoadInt32(&ran) != 0 || f.Status() != StatusPending {
+		t.Fatalf("Expected job to wait for its delay : got status %s", f.Status())
+	}
+
+	clock.Advance(time.Second)
+	r, _ := f.Wait(freshCtx())
+	if r.Data != "ran" {
+		t.Fatalf("Expected job to run once its delay passed : got %v", r.Error)
+	}
+	if n := len(wp.StopWaitXT()); n != 1 {
+		t.Fatalf("Expected 1 result : got %d", n)
+	}
+}
+
+func TestSubmitAt(t *testing.T) {
+	clock := newFakeClock()
+	wp := makeDefaultWp()
+	wp.SetClock(clock)
+	f := wp.SubmitAt(clock.Now().Add(time.Hour*2), Job{
+		Name: "at",
+		Task: func(o Options) Result { return Result{Data: "ran"} },
+	})
+
+	clock.waitForTimers(t, 1)
+	clock.Advance(time.Hour * 2)
+	if r, _ := f.Wait(freshCtx()); r.Data != "ran" {
+		t.Fatalf("Expected job to run at its time : got %v", r.Error)
+	}
+	wp.StopWaitXT()
+}
+
+func TestSubmitAfterCancel(t *testing.T) {
+	var ran int32
+	wp := makeDefaultWp()
+	f := wp.SubmitAfter(time.Hour, Job{
+		Name: "cancelled",
+		Task: func(o Options) Result {
+			atomic.StoreInt32(&ran, 1)
+			return Result{}
+		},
+	})
+	f.Cancel()
+
+	results := wp.StopWaitXT()
+	if atomic.LoadInt32(&ran) != 0 {
+		t.Fatalf("Expected cancelled scheduled job to never run")
+	}
+	if len(results) != 1 || results[0].Error != context.Canceled {
+		t.Fatalf("Expected 1 result with %s : got %v", context.Canceled, results)
+	}
+	if f.Status() != StatusCancelled {
+		t.Fatalf("Expected status %s : got %s", StatusCancelled, f.Status())
+	}
+}
+
+func TestStopWaitXTWaitsForScheduledJobs(t *testing.T) {
+	delay := time.Millisecond * 20
+	wp := makeDefaultWp()
+	start := time.Now()
+	wp.SubmitAfter(delay, Job{
+		Name: "scheduled",
+		Task: func(o Options) Result { return Result{Data: "ran"} },
+	})
+
+	results := wp.StopWaitXT()
+	if time.Since(start) < delay {
+		t.Fatalf("Expected StopWaitXT to wait for the scheduled job")
+	}
+	if len(results) != 1 || results[0].Data != "ran" {
+		t.Fatalf("Expected scheduled job to run : got %v", results)
+	}
+}
+
+func TestStopNowAbandonsScheduledJobs(t *testing.T) {
+	wp := makeDefaultWp()
+	f := wp.SubmitAfter(time.Hour, Job{
+		Name: "scheduled",
+		Task: func(o Options) Result { return Result{Data: "ran"} },
+	})
+
+	wp.stop(true)
+	if len(wp.results) != 1 || wp.results[0].Error != ErrJobNotRun {
+		t.Fatalf("Expected 1 result with %s : got %v", ErrJobNotRun, wp.results)
+	}
+	if f.Status() != StatusFailed {
+		t.Fatalf("Expected status %s : got %s", StatusFailed, f.Status())
+	}
+}
+
+func TestParseCron(t *testing.T) {
+	at := func(s string) time.Time {
+		tm, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+	tests := []struct {
+		expr  string
+		after string
+		want  string
+	}{
+		{"* * * * *", "2020-01-01 00:00", "2020-01-01 00:01"},
+		{"*/15 * * * *", "2020-01-01 00:14", "2020-01-01 00:15"},
+		{"30 9 * * 1-5", "2020-01-03 10:00", "2020-01-06 09:30"}, // Friday -> Monday
+		{"0 0 1 * *", "2020-01-15 12:00", "2020-02-01 00:00"},
+		{"0 12 29 2 *", "2020-03-01 00:00", "2024-02-29 12:00"},
+		{"0 0 13 * 5", "2020-01-01 00:00", "2020-01-03 00:00"}, // 13th or a Friday
+		{"0 0 * * 7", "2020-01-01 00:00", "2020-01-05 00:00"},  // 7 is Sunday
+		{"@hourly", "2020-01-01 00:30", "2020-01-01 01:00"},
+		{"@every 90s", "2020-01-01 00:00", "2020-01-01 00:01"},
+	}
+	for _, tt := range tests {
+		s, err := ParseCron(tt.expr)
+		if err != nil {
+			t.Fatalf("Expected '%s' to parse : got %v", tt.expr, err)
+		}
+		want := at(tt.want)
+		if tt.expr == "@every 90s" {
+			want = want.Add(time.Second * 30)
+		}
+		if got := s.Next(at(tt.after)); !got.Equal(want) {
+			t.Fatalf("Expected '%s' after %s to be %s : got %s", tt.expr, tt.after, want, got)
+		}
+	}
+
+	if s, _ := ParseCron("0 0 30 2 *"); !s.Next(at("2020-01-01 00:00")).IsZero() {
+		t.Fatalf("Expected a schedule that never matches to return the zero time")
+	}
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "5-1 * * * *", "*/0 * * * *", "a * * * *", "@every nope"} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Fatalf("Expected '%s' to be invalid", expr)
+		}
+	}
+}
+
+func TestSubmitRecurring(t *testing.T) {
+	clock := newFakeClock()
+	wp := makeDefaultWp()
+	wp.SetClock(clock)
+	rec := wp.SubmitRecurring(RecurringJob{
+		Schedule: Every(time.Minute),
+		Job: Job{
+			Name: "recurring",
+			Task: func(o Options) Result { return Result{Data: "ran"} },
+		},
+	})
+
+	for i := 0; i < 3; i++ {
+		clock.waitForTimers(t, 1)
+		clock.Advance(time.Minute)
+	}
+	// The next timer is only set once the previous run was submitted
+	clock.waitForTimers(t, 1)
+	rec.Stop()
+
+	results := wp.StopWaitXT()
+	if len(results) != 3 {
+		t.Fatalf("Expected 3 results : got %d", len(results))
+	}
+	for _, r := range results {
+		if r.Name() != "recurring" || r.Data != "ran" {
+			t.Fatalf("Expected every run to produce a result : got %v", r)
+		}
+	}
+}
+
+func TestSubmitRecurringSkipsOverlap(t *testing.T) {
+	for _, allow := range []bool{false, true} {
+		clock := newFakeClock()
+		release := make(chan struct{})
+		wp := makeDefaultWp()
+		wp.SetClock(clock)
+		rec := wp.SubmitRecurring(RecurringJob{
+			Schedule:     Every(time.Minute),
+			AllowOverlap: allow,
+			Job: Job{
+				Name: "slow",
+				Task: func(o Options) Result {
+					<-release
+					return Result{}
+				},
+			},
+		})
+
+		for i := 0; i < 3; i++ {
+			clock.waitForTimers(t, 1)
+			clock.Advance(time.Minute)
+		}
+		clock.waitForTimers(t, 1)
+		rec.Stop()
+		close(release)
+		results := wp.StopWaitXT()
+
+		want, skipped := 1, 2
+		if allow {
+			want, skipped = 3, 0
+		}
+		if rec.Runs() != want || rec.Skipped() != skipped || len(results) != want {
+			t.Fatalf("AllowOverlap %v : expected %d runs and %d skipped : got %d runs, %d skipped and %d results",
+				allow, want, skipped, rec.Runs(), rec.Skipped(), len(results))
+		}
+	}
+}
+
+func TestStopWaitXTStopsRecurringJobs(t *testing.T) {
+	wp := makeDefaultWp()
+	rec := wp.SubmitRecurring(RecurringJob{
+		Schedule: Every(time.Hour),
+		Job:      Job{Name: "hourly", Task: func(o Options) Result { return Result{} }},
+	})
+
+	done := make(chan struct{})
+	go func() {
+		wp.StopWaitXT()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatalf("Expected StopWaitXT to stop recurring jobs")
+	}
+	if rec.Runs() != 0 {
+		t.Fatalf("Expected no runs : got %d", rec.Runs())
+	}
+}
+
+// fillQueue starts a job that blocks until release is closed, on a pool with 1 worker
+func fillQueue(t *testing.T, maxQueue int, policy QueuePolicy) (wp *WorkerPoolXT, release chan struct{}) {
+	started := make(chan struct{})
+	release = make(chan struct{})
+	wp = New(freshCtx(), 1)
+	wp.SetMaxQueue(maxQueue, policy)
+	wp.SubmitXT(Job{
+		Name: "blocker",
+		Task: func(o Options) Result {
+			close(started)
+			<-release
+			return Result{}
+		},
+	})
+	<-started
+	return wp, release
+}
+
+func TestQueueReject(t *testing.T) {
+	wp, release := fillQueue(t, 1, QueueReject)
+	if err := wp.TrySubmitXT(Job{Name: "a", Task: func(o Options) Result { return Result{} }}); err != nil {
+		t.Fatalf("Expected job to be accepted : got %v", err)
+	}
+	if err := wp.TrySubmitXT(Job{Name: "b", Task: func(o Options) Result { return Result{} }}); err != ErrQueueFull {
+		t.Fatalf("Expected %s : got %v", ErrQueueFull, err)
+	}
+	if err := wp.SubmitXTCtx(freshCtx(), Job{Name: "c", Task: func(o Options) Result { return Result{} }}); err != ErrQueueFull {
+		t.Fatalf("Expected %s : got %v", ErrQueueFull, err)
+	}
+	f := wp.SubmitFutureXT(Job{Name: "d", Task: func(o Options) Result { return Result{} }})
+	close(release)
+
+	if r, _ := f.Wait(freshCtx()); r.Error != ErrQueueFull {
+		t.Fatalf("Expected SubmitXT to give a rejected job a result with %s : got %v", ErrQueueFull, r.Error)
+	}
+	// b and c were never accepted so they have no result
+	results := wp.StopWaitXT()
+	if len(results) != 3 {
+		t.Fatalf("Expected 3 results : got %d", len(results))
+	}
+}
+
+func TestQueueBlock(t *testing.T) {
+	wp, release := fillQueue(t, 1, QueueBlock)
+	wp.SubmitXT(Job{Name: "a", Task: func(o Options) Result { return Result{} }})
+	if err := wp.TrySubmitXT(Job{Name: "b", Task: func(o Options) Result { return Result{} }}); err != ErrQueueFull {
+		t.Fatalf("Expected %s : got %v", ErrQueueFull, err)
+	}
+	ctx, cancel := context.WithTimeout(freshCtx(), time.Millisecond*10)
+	defer cancel()
+	if err := wp.SubmitXTCtx(ctx, Job{Name: "c", Task: func(o Options) Result { return Result{} }}); err != context.DeadlineExceeded {
+		t.Fatalf("Expected %s : got %v", context.DeadlineExceeded, err)
+	}
+
+	submitted := make(chan struct{})
+	go func() {
+		wp.SubmitXT(Job{Name: "d", Task: func(o Options) Result { return Result{} }})
+		close(submitted)
+	}()
+	select {
+	case <-submitted:
+		t.Fatalf("Expected SubmitXT to block while the queue is full")
+	case <-time.After(time.Millisecond * 20):
+	}
+	close(release)
+	select {
+	case <-submitted:
+	case <-time.After(time.Second * 5):
+		t.Fatalf("Expected SubmitXT to return once there was room")
+	}
+
+	if n := len(wp.StopWaitXT()); n != 3 {
+		t.Fatalf("Expected 3 results : got %d", n)
+	}
+}
+
+func TestQueueDrop(t *testing.T) {
+	tests := []struct {
+		policy  QueuePolicy
+		dropped string
+	}{
+		{QueueDropOldest, "a"},
+		{QueueDropNewest, "c"},
+	}
+	for _, tt := range tests {
+		wp, release := fillQueue(t, 2, tt.policy)
+		for _, name := range []string{"a", "b", "c"} {
+			wp.SubmitXT(Job{Name: name, Task: func(o Options) Result { return Result{} }})
+		}
+		close(release)
+
+		results := wp.StopWaitXT()
+		if len(results) != 4 {
+			t.Fatalf("Expected 4 results : got %d", len(results))
+		}
+		for _, r := range results {
+			if (r.Error == ErrJobDropped) != (r.Name() == tt.dropped) {
+				t.Fatalf("Expected only '%s' to be dropped : got %s for '%s'", tt.dropped, r.Error, r.Name())
+			}
+		}
+	}
+}
+
+func TestTrySubmitXTDropNewest(t *testing.T) {
+	wp, release := fillQueue(t, 1, QueueDropNewest)
+	if err := wp.TrySubmitXT(Job{Name: "a", Task: func(o Options) Result { return Result{} }}); err != nil {
+		t.Fatalf("Expected job to be accepted : got %v", err)
+	}
+	if err := wp.TrySubmitXT(Job{Name: "b", Task: func(o Options) Result { return Result{} }}); err != ErrQueueFull {
+		t.Fatalf("Expected %s : got %v", ErrQueueFull, err)
+	}
+	if err := wp.SubmitXTCtx(freshCtx(), Job{Name: "c", Task: func(o Options) Result { return Result{} }}); err != ErrQueueFull {
+		t.Fatalf("Expected %s : got %v", ErrQueueFull, err)
+	}
+	close(release)
+
+	// b and c were never accepted so they have no result
+	results := wp.StopWaitXT()
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 results : got %d", len(results))
+	}
+	for _, r := range results {
+		if r.Error != nil {
+			t.Fatalf("Expected '%s' to run : got %v", r.Name(), r.Error)
+		}
+	}
+}
+
+func TestQueueDropOldestBoundsWorkerPoolQueue(t *testing.T) {
+	wp, release := fillQueue(t, 1, QueueDropOldest)
+	for i := 0; i < 1000; i++ {
+		wp.SubmitXT(Job{Name: fmt.Sprintf("job %d", i), Task: func(o Options) Result { return Result{} }})
+	}
+	deadline := time.Now().Add(time.Second * 5)
+	for wp.WaitingQueueSize() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected 1 func waiting in the WorkerPool : got %d", wp.WaitingQueueSize())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+
+	results := wp.StopWaitXT()
+	if len(results) != 1001 {
+		t.Fatalf("Expected 1001 results : got %d", len(results))
+	}
+	ran := 0
+	for _, r := range results {
+		if r.Error == nil {
+			ran++
+		}
+	}
+	if ran != 2 {
+		t.Fatalf("Expected the blocker and the newest job to run : got %d", ran)
+	}
+}
+
+func TestQueueDropOldestAfterLoweringMaxQueue(t *testing.T) {
+	wp := New(freshCtx(), 1)
+	wp.Pause()
+	var futures []*Future
+	for i := 0; i < 10; i++ {
+		futures = append(futures, wp.SubmitFutureXT(Job{Name: fmt.Sprintf("job %d", i), Task: func(o Options) Result { return Result{} }}))
+	}
+	wp.SetMaxQueue(2, QueueDropOldest)
+	futures = append(futures, wp.SubmitFutureXT(Job{Name: "newest", Task: func(o Options) Result { return Result{} }}))
+	wp.Resume()
+
+	results := wp.StopWaitXT()
+	if len(results) != 11 {
+		t.Fatalf("Expected 11 results : got %d", len(results))
+	}
+	dropped := 0
+	for _, f := range futures {
+		select {
+		case <-f.Done():
+		default:
+			t.Fatalf("Expected the Future of %s to be completed", f.Name())
+		}
+		if r, _ := f.Wait(freshCtx()); r.Error == ErrJobDropped {
+			dropped++
+		}
+	}
+	if dropped != 9 {
+		t.Fatalf("Expected 9 jobs to be dropped : got %d", dropped)
+	}
+}
+
+func TestSubmitWaitXT(t *testing.T) {
+	wp := NewWithOptions(freshCtx(), defaultWorkers, Options{"x": "default"})
+	r := wp.SubmitWaitXT(Job{
+		Name: "wait",
+		Task: func(o Options) Result { return Result{Data: o["x"]} },
+	})
+	if r.Name() != "wait" || r.Data != "default" {
+		t.Fatalf("Expected result of the job, with default options : got %v", r)
+	}
+
+	attempts := 0
+	r = wp.SubmitWaitXT(Job{
+		Name:        "retried",
+		Retry:       2,
+		RetryPolicy: ConstantRetry{Interval: time.Millisecond},
+		Task: func(o Options) Result {
+			attempts++
+			return Result{Error: errors.New("nope")}
+		},
+	})
+	if attempts != 3 || len(r.Attempts()) != 3 {
+		t.Fatalf("Expected job to be retried : got %d attempts", attempts)
+	}
+
+	if n := len(wp.StopWaitXT()); n != 2 {
+		t.Fatalf("Expected results to still be collected : got %d", n)
+	}
+}
+
+func TestSubmitWaitXTCtx(t *testing.T) {
+	wp := makeDefaultWp()
+	r, err := wp.SubmitWaitXTCtx(freshCtx(), Job{
+		Name: "wait",
+		Task: func(o Options) Result { return Result{Data: "done"} },
+	})
+	if err != nil || r.Data != "done" {
+		t.Fatalf("Expected result of the job : got %v %v", r, err)
+	}
+
+	ctx, cancel := context.WithTimeout(freshCtx(), time.Millisecond*20)
+	defer cancel()
+	_, err = wp.SubmitWaitXTCtx(ctx, Job{
+		Name: "slow",
+		TaskCtx: func(ctx context.Context, o Options) Result {
+			<-ctx.Done()
+			return Result{Error: ctx.Err()}
+		},
+	})
+	if err != context.DeadlineExceeded {
+		t.Fatalf("Expected %s : got %v", context.DeadlineExceeded, err)
+	}
+
+	results := wp.StopWaitXT()
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 results : got %d", len(results))
+	}
+	for _, r := range results {
+		if r.Name() == "slow" && r.Error != context.Canceled {
+			t.Fatalf("Expected slow job to be cancelled : got %v", r.Error)
+		}
+	}
+}
+
+func TestTypedSubmitWaitXT(t *testing.T) {
+	wp := NewTyped[int, int](freshCtx(), defaultWorkers)
+	r := wp.SubmitWaitXT(TypedJob[int, int]{
+		Job:     Job{Name: "double"},
+		Options: 21,
+		Task:    func(n int) TypedResult[int] { return TypedResult[int]{Data: n * 2} },
+	})
+	if r.Data != 42 {
+		t.Fatalf("Expected 42 : got %d", r.Data)
+	}
+	wp.StopWaitXT()
+}
+
+func TestShutdownDrains(t *testing.T) {
+	wp := New(freshCtx(), 2)
+	for i := 0; i < 10; i++ {
+		wp.SubmitXT(Job{
+			Name: fmt.Sprintf("job %d", i),
+			Task: func(o Options) Result {
+				time.Sleep(time.Millisecond)
+				return Result{Data: "ran"}
+			},
+		})
+	}
+	wp.SubmitAfter(time.Millisecond*10, Job{Name: "scheduled", Task: func(o Options) Result { return Result{Data: "ran"} }})
+
+	results, err := wp.Shutdown(freshCtx())
+	if err != nil {
+		t.Fatalf("Expected no error : got %v", err)
+	}
+	if len(results) != 11 {
+		t.Fatalf("Expected 11 results : got %d", len(results))
+	}
+	for _, r := range results {
+		if r.Error != nil {
+			t.Fatalf("Expected every job to run : got %v for '%s'", r.Error, r.Name())
+		}
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	started := make(chan struct{})
+	wp := New(freshCtx(), 1)
+	running := wp.SubmitFutureXT(Job{
+		Name: "running",
+		TaskCtx: func(ctx context.Context, o Options) Result {
+			close(started)
+			<-ctx.Done()
+			return Result{Error: ctx.Err()}
+		},
+	})
+	wp.SubmitXT(Job{Name: "queued 1", Task: func(o Options) Result { return Result{} }})
+	wp.SubmitXT(Job{Name: "queued 2", Task: func(o Options) Result { return Result{} }})
+	wp.SubmitAfter(time.Hour, Job{Name: "scheduled", Task: func(o Options) Result { return Result{} }})
+	<-started
+
+	ctx, cancel := context.WithTimeout(freshCtx(), time.Millisecond*20)
+	defer cancel()
+	results, err := wp.Shutdown(ctx)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("Expected %s : got %v", context.DeadlineExceeded, err)
+	}
+	if len(results) != 4 {
+		t.Fatalf("Expected a result for every job : got %d", len(results))
+	}
+	for _, r := range results {
+		expected := ErrJobNotRun
+		if r.Name() == "running" {
+			expected = ErrCancelledAtShutdown
+		}
+		if r.Error != expected {
+			t.Fatalf("Expected '%s' to have %s : got %v", r.Name(), expected, r.Error)
+		}
+	}
+	if running.Status() != StatusCancelled {
+		t.Fatalf("Expected running job to be %s : got %s", StatusCancelled, running.Status())
+	}
+}
+
+func TestShutdownStopsAccepting(t *testing.T) {
+	wp := makeDefaultWp()
+	if _, err := wp.Shutdown(freshCtx()); err != nil {
+		t.Fatalf("Expected no error : got %v", err)
+	}
+
+	if err := wp.TrySubmitXT(Job{Name: "late", Task: func(o Options) Result { return Result{} }}); err != ErrPoolStopped {
+		t.Fatalf("Expected %s : got %v", ErrPoolStopped, err)
+	}
+	f := wp.SubmitFutureXT(Job{Name: "late", Task: func(o Options) Result { return Result{} }})
+	if r, _ := f.Wait(freshCtx()); r.Error != ErrPoolStopped {
+		t.Fatalf("Expected %s : got %v", ErrPoolStopped, r.Error)
+	}
+	f = wp.SubmitAfter(time.Second, Job{Name: "late", Task: func(o Options) Result { return Result{} }})
+	if r, _ := f.Wait(freshCtx()); r.Error != ErrPoolStopped {
+		t.Fatalf("Expected %s : got %v", ErrPoolStopped, r.Error)
+	}
+}
+
+// blockedSubmit submits a job from a goroutine and waits until it is blocked on a full queue
+func blockedSubmit(t *testing.T, wp *WorkerPoolXT, j Job) <-chan *Future {
+	submitted := make(chan *Future, 1)
+	go func() {
+		submitted <- wp.SubmitFutureXT(j)
+	}()
+	select {
+	case <-submitted:
+		t.Fatalf("Expected SubmitXT to block while the queue is full")
+	case <-time.After(time.Millisecond * 20):
+	}
+	return submitted
+}
+
+func TestStopXTWithBlockedSubmitter(t *testing.T) {
+	wp := New(freshCtx(), 1)
+	wp.Pause()
+	wp.SetMaxQueue(1, QueueBlock)
+	wp.SubmitXT(Job{Name: "queued", Task: func(o Options) Result { return Result{} }})
+	submitted := blockedSubmit(t, wp, Job{Name: "blocked", Task: func(o Options) Result { return Result{} }})
+
+	results := wp.StopXT()
+	if len(results) != 2 {
+		t.Fatalf("Expected a result for the queued and the blocked job : got %d", len(results))
+	}
+	for _, r := range results {
+		if r.Error != ErrJobNotRun {
+			t.Fatalf("Expected every result to have %s : got %v", ErrJobNotRun, r.Error)
+		}
+	}
+	if r, _ := (<-submitted).Wait(freshCtx()); r.Error != ErrJobNotRun {
+		t.Fatalf("Expected blocked job to have %s : got %v", ErrJobNotRun, r.Error)
+	}
+}
+
+func TestShutdownTimeoutWithBlockedSubmitter(t *testing.T) {
+	wp, release := fillQueue(t, 1, QueueBlock)
+	defer close(release)
+	wp.SubmitXT(Job{Name: "queued", Task: func(o Options) Result { return Result{} }})
+	submitted := blockedSubmit(t, wp, Job{Name: "blocked", Task: func(o Options) Result { return Result{} }})
+
+	ctx, cancel := context.WithTimeout(freshCtx(), time.Millisecond*20)
+	defer cancel()
+	results, err := wp.Shutdown(ctx)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("Expected %s : got %v", context.DeadlineExceeded, err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("Expected a result for the blocker, the queued and the blocked job : got %d", len(results))
+	}
+	if r, _ := (<-submitted).Wait(freshCtx()); r.Error != ErrJobNotRun {
+		t.Fatalf("Expected blocked job to have %s : got %v", ErrJobNotRun, r.Error)
+	}
+}
+
+func TestPauseResume(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var ran int32
+	wp := makeDefaultWp()
+	running := wp.SubmitFutureXT(Job{
+		Name: "running",
+		Task: func(o Options) Result {
+			close(started)
+			<-release
+			return Result{}
+		},
+	})
+	<-started
+
+	wp.Pause()
+	if !wp.IsPaused() {
+		t.Fatalf("Expected pool to be paused")
+	}
+	for i := 0; i < 5; i++ {
+		wp.SubmitXT(Job{
+			Name: fmt.Sprintf("job %d", i),
+			Task: func(o Options) Result {
+				atomic.AddInt32(&ran, 1)
+				return Result{}
+			},
+		})
+	}
+	close(release)
+	if _, err := running.Wait(freshCtx()); err != nil {
+		t.Fatalf("Expected running job to finish while paused : got %v", err)
+	}
+	time.Sleep(time.Millisecond * 20)
+	if n := atomic.LoadInt32(&ran); n != 0 {
+		t.Fatalf("Expected no jobs to start while paused : got %d", n)
+	}
+
+	wp.Resume()
+	if wp.IsPaused() {
+		t.Fatalf("Expected pool to be resumed")
+	}
+	if n := len(wp.StopWaitXT()); n != 6 {
+		t.Fatalf("Expected 6 results : got %d", n)
+	}
+	if n := atomic.LoadInt32(&ran); n != 5 {
+		t.Fatalf("Expected every job to run once resumed : got %d", n)
+	}
+}
+
+func TestPauseDoesNotHoldWorkers(t *testing.T) {
+	wp := New(freshCtx(), 2)
+	wp.Pause()
+	for i := 0; i < 4; i++ {
+		wp.SubmitXT(Job{Name: fmt.Sprintf("Job %d", i), Task: func(o Options) Result { return Result{} }})
+	}
+
+	expectSubmitRuns(t, wp)
+	wp.Resume()
+	if n := len(wp.StopWaitXT()); n != 4 {
+		t.Fatalf("Expected 4 results : got %d", n)
+	}
+}
+
+func TestStopWaitXTResumes(t *testing.T) {
+	wp := makeDefaultWp()
+	wp.Pause()
+	wp.SubmitXT(Job{Name: "paused", Task: func(o Options) Result { return Result{Data: "ran"} }})
+
+	results := wp.StopWaitXT()
+	if len(results) != 1 || results[0].Data != "ran" {
+		t.Fatalf("Expected StopWaitXT to resume and run the job : got %v", results)
+	}
+	if wp.IsPaused() {
+		t.Fatalf("Expected stopping to resume the pool")
+	}
+}
+
+func TestPauseScheduledJobs(t *testing.T) {
+	clock := newFakeClock()
+	wp := makeDefaultWp()
+	wp.SetClock(clock)
+	wp.Pause()
+	f := wp.SubmitAfter(time.Minute, Job{Name: "scheduled", Task: func(o Options) Result { return Result{Data: "ran"} }})
+
+	clock.waitForTimers(t, 1)
+	clock.Advance(time.Minute)
+	time.Sleep(time.Millisecond * 20)
+	if f.Status() != StatusPending {
+		t.Fatalf("Expected scheduled job to wait while paused : got %s", f.Status())
+	}
+
+	wp.Resume()
+	if r, _ := f.Wait(freshCtx()); r.Data != "ran" {
+		t.Fatalf("Expected scheduled job to run once resumed : got %v", r.Error)
+	}
+	wp.StopWaitXT()
+}
+
+// waitForCount blocks until n reaches expected
+func waitForCount(t *testing.T, n *int32, expected int32) {
+	deadline := time.Now().Add(time.Second * 5)
+	for atomic.LoadInt32(n) != expected {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected count %d : got %d", expected, atomic.LoadInt32(n))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	clock := newFakeClock()
+	var started int32
+	wp := New(freshCtx(), 4)
+	wp.SetClock(clock)
+	wp.SetRateLimit(RateLimit{PerSecond: 1, Burst: 2})
+	for i := 0; i < 4; i++ {
+		wp.SubmitXT(Job{
+			Name: fmt.Sprintf("job %d", i),
+			Task: func(o Options) Result {
+				atomic.AddInt32(&started, 1)
+				return Result{}
+			},
+		})
+	}
+
+	// The burst starts right away, the other 2 wait for their tokens
+	clock.waitForTimers(t, 1)
+	waitForCount(t, &started, 2)
+	clock.Advance(time.Second)
+	waitForCount(t, &started, 3)
+	clock.waitForTimers(t, 1)
+	clock.Advance(time.Second)
+	waitForCount(t, &started, 4)
+
+	if n := len(wp.StopWaitXT()); n != 4 {
+		t.Fatalf("Expected 4 results : got %d", n)
+	}
+}
+
+func TestKeyRateLimit(t *testing.T) {
+	clock := newFakeClock()
+	var startedA, startedB int32
+	wp := New(freshCtx(), 4)
+	wp.SetClock(clock)
+	wp.SetKeyRateLimit("a", RateLimit{PerSecond: 1})
+	for i := 0; i < 2; i++ {
+		wp.SubmitXT(Job{Name: "a", RateKey: "a", Task: func(o Options) Result {
+			atomic.AddInt32(&startedA, 1)
+			return Result{}
+		}})
+		wp.SubmitXT(Job{Name: "b", RateKey: "b", Task: func(o Options) Result {
+			atomic.AddInt32(&startedB, 1)
+			return Result{}
+		}})
+	}
+
+	clock.waitForTimers(t, 1)
+	waitForCount(t, &startedB, 2)
+	waitForCount(t, &startedA, 1)
+	clock.Advance(time.Second)
+	waitForCount(t, &startedA, 2)
+	wp.StopWaitXT()
+}
+
+func TestKeyRateLimitDoesNotHoldWorkers(t *testing.T) {
+	clock := newFakeClock()
+	var slow int32
+	wp := New(freshCtx(), 2)
+	wp.SetClock(clock)
+	wp.SetKeyRateLimit("slow", RateLimit{PerSecond: 1})
+	for i := 0; i < 4; i++ {
+		wp.SubmitXT(Job{Name: "slow", RateKey: "slow", Task: func(o Options) Result {
+			atomic.AddInt32(&slow, 1)
+			return Result{}
+		}})
+	}
+
+	// Jobs waiting for a token must not keep an unlimited job from starting
+	ctx, cancel := context.WithTimeout(freshCtx(), time.Second*5)
+	defer cancel()
+	r, err := wp.SubmitWaitXTCtx(ctx, Job{Name: "unlimited", Task: func(o Options) Result { return Result{Data: "ran"} }})
+	if err != nil || r.Data != "ran" {
+		t.Fatalf("Expected unlimited job to run while others wait for a token : got %v", err)
+	}
+
+	for i := int32(1); i < 4; i++ {
+		waitForCount(t, &slow, i)
+		clock.waitForTimers(t, 1)
+		clock.Advance(time.Second)
+	}
+	if n := len(wp.StopWaitXT()); n != 5 {
+		t.Fatalf("Expected 5 results : got %d", n)
+	}
+	if n := atomic.LoadInt32(&slow); n != 4 {
+		t.Fatalf("Expected every rate limited job to run : got %d", n)
+	}
+}
+
+func TestRateLimitHonorsJobContext(t *testing.T) {
+	clock := newFakeClock()
+	wp := makeDefaultWp()
+	wp.SetClock(clock)
+	wp.SetRateLimit(RateLimit{PerSecond: 1})
+	wp.SubmitWaitXT(Job{Name: "first", Task: func(o Options) Result { return Result{} }})
+
+	ctx, cancel := context.WithTimeout(freshCtx(), time.Millisecond*20)
+	defer cancel()
+	var ran int32
+	r := wp.SubmitWaitXT(Job{
+		Name:    "second",
+		Context: ctx,
+		Task: func(o Options) Result {
+			atomic.StoreInt32(&ran, 1)
+			return Result{}
+		},
+	})
+	if r.Error != context.DeadlineExceeded || atomic.LoadInt32(&ran) != 0 {
+		t.Fatalf("Expected job to give up waiting for the rate limit : got %v", r.Error)
+	}
+
+	// The token the second job gave up on is still there for the next
+	clock.Advance(time.Second)
+	if r := wp.SubmitWaitXT(Job{Name: "third", Task: func(o Options) Result { return Result{Data: "ran"} }}); r.Data != "ran" {
+		t.Fatalf("Expected third job to run : got %v", r.Error)
+	}
+	wp.StopWaitXT()
+}
+
+func TestRateLimitKeepsKeyTokenOfJobThatGaveUp(t *testing.T) {
+	clock := newFakeClock()
+	wp := makeDefaultWp()
+	wp.SetClock(clock)
+	wp.SetRateLimit(RateLimit{PerSecond: 2})
+	wp.SetKeyRateLimit("k", RateLimit{PerSecond: 1})
+	wp.SubmitWaitXT(Job{Name: "first", Task: func(o Options) Result { return Result{} }})
+
+	// The key has a token, the pool doesn't
+	ctx, cancel := context.WithTimeout(freshCtx(), time.Millisecond*20)
+	defer cancel()
+	if r := wp.SubmitWaitXT(Job{Name: "second", RateKey: "k", Context: ctx, Task: func(o Options) Result { return Result{} }}); r.Error != context.DeadlineExceeded {
+		t.Fatalf("Expected job to give up waiting for the rate limit : got %v", r.Error)
+	}
+
+	// Half a second refills the pool but not the key, so the key token must still be there
+	clock.Advance(time.Millisecond * 500)
+	waitCtx, waitCancel := context.WithTimeout(freshCtx(), time.Second*5)
+	defer waitCancel()
+	r, err := wp.SubmitWaitXTCtx(waitCtx, Job{Name: "third", RateKey: "k", Task: func(o Options) Result { return Result{Data: "ran"} }})
+	if err != nil || r.Data != "ran" {
+		t.Fatalf("Expected third job to run with the key token the second gave up : got %v", err)
+	}
+	wp.StopWaitXT()
+}
+
+func TestCapacity(t *testing.T) {
+	tracker := &concurrencyTracker{}
+	var used, maxUsed int32
+	var mu sync.Mutex
+	wp := New(freshCtx(), 10)
+	wp.SetCapacity(Resources{"cpu": 4})
+	for i := 0; i < 20; i++ {
+		cpu := 1 + i%3
+		wp.SubmitXT(Job{
+			Name:      fmt.Sprintf("job %d", i),
+			Resources: Resources{"cpu": cpu, "unlimited": 100},
+			Task: func(o Options) Result {
+				tracker.start()
+				defer tracker.end()
+				mu.Lock()
+				used += int32(cpu)
+				if used > maxUsed {
+					maxUsed = used
+				}
+				mu.Unlock()
+				time.Sleep(time.Millisecond * 5)
+				mu.Lock()
+				used -= int32(cpu)
+				mu.Unlock()
+				return Result{}
+			},
+		})
+	}
+
+	if n := len(wp.StopWaitXT()); n != 20 {
+		t.Fatalf("Expected 20 results : got %d", n)
+	}
+	if maxUsed > 4 {
+		t.Fatalf("Expected running jobs to use at most 4 cpu : got %d", maxUsed)
+	}
+	if atomic.LoadInt32(&tracker.max) < 2 {
+		t.Fatalf("Expected light jobs to run alongside each other : got %d at most", atomic.LoadInt32(&tracker.max))
+	}
+}
+
+func TestCapacityOversizedJob(t *testing.T) {
+	wp := New(freshCtx(), 2)
+	wp.SetCapacity(Resources{"memMB": 1024})
+	r := wp.SubmitWaitXT(Job{
+		Name:      "huge",
+		Resources: Resources{"memMB": 4096},
+		Task:      func(o Options) Result { return Result{Data: "ran"} },
+	})
+	if r.Data != "ran" {
+		t.Fatalf("Expected a job needing more than the capacity to still run : got %v", r.Error)
+	}
+	wp.StopWaitXT()
+}
+
+func TestCapacityHoldsBackSmallerJobs(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var order []string
+	var mu sync.Mutex
+	record := func(name string) func(o Options) Result {
+		return func(o Options) Result {
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			return Result{}
+		}
+	}
+
+	wp := New(freshCtx(), 3)
+	wp.SetCapacity(Resources{"cpu": 2})
+	wp.SubmitXT(Job{Name: "running", Resources: Resources{"cpu": 1}, Task: func(o Options) Result {
+		close(started)
+		<-release
+		return Result{}
+	}})
+	<-started
+	wp.SubmitXT(Job{Name: "heavy", Resources: Resources{"cpu": 2}, Task: record("heavy")})
+	wp.SubmitXT(Job{Name: "light", Resources: Resources{"cpu": 1}, Task: record("light")})
+	time.Sleep(time.Millisecond * 20)
+	close(release)
+	wp.StopWaitXT()
+
+	if len(order) != 2 || order[0] != "heavy" {
+		t.Fatalf("Expected heavy job to start before the light job behind it : got %v", order)
+	}
+}
+
+// startOrder runs queued jobs on a single worker once release is closed and
+// records the queue of each job in the order they started
+func startOrder(t *testing.T, wp *WorkerPoolXT, queued func(record func(string) func(Options) Result)) []string {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var order []string
+	var mu sync.Mutex
+	wp.SubmitXT(Job{Name: "blocker", Task: func(o Options) Result {
+		close(started)
+		<-release
+		return Result{}
+	}})
+	<-started
+	queued(func(queue string) func(Options) Result {
+		return func(o Options) Result {
+			mu.Lock()
+			defer mu.Unlock()
+			order = append(order, queue)
+			return Result{}
+		}
+	})
+	close(release)
+	wp.StopWaitXT()
+	return order
+}
+
+func TestQueuesShareFairly(t *testing.T) {
+	wp := New(freshCtx(), 1)
+	order := startOrder(t, wp, func(record func(string) func(Options) Result) {
+		for i := 0; i < 20; i++ {
+			wp.SubmitXT(Job{Name: "noisy", Queue: "noisy", Task: record("noisy")})
+		}
+		for i := 0; i < 2; i++ {
+			wp.SubmitXT(Job{Name: "quiet", Queue: "quiet", Task: record("quiet")})
+		}
+	})
+
+	quiet := 0
+	for _, q := range order[:4] {
+		if q == "quiet" {
+			quiet++
+		}
+	}
+	if quiet != 2 {
+		t.Fatalf("Expected quiet queue to take turns with the noisy one : got %v", order)
+	}
+}
+
+func TestQueueWeights(t *testing.T) {
+	wp := New(freshCtx(), 1)
+	wp.SetQueue("a", QueueConfig{Weight: 3})
+	order := startOrder(t, wp, func(record func(string) func(Options) Result) {
+		for i := 0; i < 20; i++ {
+			wp.SubmitXT(Job{Name: "a", Queue: "a", Task: record("a")})
+		}
+		for i := 0; i < 20; i++ {
+			wp.SubmitXT(Job{Name: "b", Queue: "b", Task: record("b")})
+		}
+	})
+
+	a := 0
+	for _, q := range order[:8] {
+		if q == "a" {
+			a++
+		}
+	}
+	if a != 6 {
+		t.Fatalf("Expected queue with weight 3 to start 6 of the first 8 jobs : got %d in %v", a, order[:8])
+	}
+}
+
+func TestQueueMaxRunning(t *testing.T) {
+	capped, other := &concurrencyTracker{}, &concurrencyTracker{}
+	wp := New(freshCtx(), 4)
+	wp.SetQueue("capped", QueueConfig{MaxRunning: 1})
+	for i := 0; i < 8; i++ {
+		wp.SubmitXT(Job{Name: "capped", Queue: "capped", Task: func(o Options) Result {
+			capped.start()
+			defer capped.end()
+			time.Sleep(time.Millisecond * 5)
+			return Result{}
+		}})
+		wp.SubmitXT(Job{Name: "other", Queue: "other", Task: func(o Options) Result {
+			other.start()
+			defer other.end()
+			time.Sleep(time.Millisecond * 5)
+			return Result{}
+		}})
+	}
+	wp.StopWaitXT()
+
+	if n := atomic.LoadInt32(&capped.max); n != 1 {
+		t.Fatalf("Expected at most 1 capped job at once : got %d", n)
+	}
+	if n := atomic.LoadInt32(&other.max); n < 2 {
+		t.Fatalf("Expected the cap to not hold back other queues : got %d at most", n)
+	}
+}
+
+func TestQueueStats(t *testing.T) {
+	wp := makeDefaultWp()
+	wp.SetQueue("idle", QueueConfig{Weight: 2})
+	for i := 0; i < 3; i++ {
+		wp.SubmitXT(Job{Name: "ok", Queue: "a", Task: func(o Options) Result { return Result{} }})
+	}
+	wp.SubmitXT(Job{Name: "fail", Queue: "a", Task: func(o Options) Result { return Result{Error: errors.New("nope")} }})
+	wp.SubmitXT(Job{Name: "default", Task: func(o Options) Result { return Result{} }})
+	wp.StopWaitXT()
+
+	stats := wp.QueueStats()
+	expected := map[string]QueueStats{
+		"a":    {Completed: 4, Failed: 1},
+		"":     {Completed: 1},
+		"idle": {},
+	}
+	if !reflect.DeepEqual(stats, expected) {
+		t.Fatalf("Expected %v : got %v", expected, stats)
+	}
+}
+
+func TestConcurrencyKey(t *testing.T) {
+	for _, perKey := range []int32{1, 2} {
+		trackers := map[string]*concurrencyTracker{"a": {}, "b": {}}
+		all := &concurrencyTracker{}
+		wp := New(freshCtx(), 6)
+		if perKey != 1 {
+			wp.SetKeyConcurrency(int(perKey))
+		}
+		for i := 0; i < 30; i++ {
+			key := []string{"a", "b", ""}[i%3]
+			wp.SubmitXT(Job{
+				Name:           fmt.Sprintf("job %d", i),
+				ConcurrencyKey: key,
+				Task: func(o Options) Result {
+					all.start()
+					defer all.end()
+					if tracker, ok := trackers[key]; ok {
+						tracker.start()
+						defer tracker.end()
+					}
+					time.Sleep(time.Millisecond * 5)
+					return Result{}
+				},
+			})
+		}
+
+		if n := len(wp.StopWaitXT()); n != 30 {
+			t.Fatalf("Expected 30 results : got %d", n)
+		}
+		for key, tracker := range trackers {
+			if n := atomic.LoadInt32(&tracker.max); n != perKey {
+				t.Fatalf("Expected at most %d jobs with key '%s' at once : got %d", perKey, key, n)
+			}
+		}
+		if n := atomic.LoadInt32(&all.max); n <= perKey*2 {
+			t.Fatalf("Expected jobs with different keys to run at once : got %d at most", n)
+		}
+	}
+}
+
+func TestConcurrencyKeyDoesNotBlockOtherKeys(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	wp := New(freshCtx(), 2)
+	wp.SubmitXT(Job{Name: "a", ConcurrencyKey: "a", Task: func(o Options) Result {
+		close(started)
+		<-release
+		return Result{Data: "ran"}
+	}})
+	<-started
+	for i := 0; i < 5; i++ {
+		wp.SubmitXT(Job{Name: "a waiting", ConcurrencyKey: "a", Task: func(o Options) Result { return Result{} }})
+	}
+
+	ctx, cancel := context.WithTimeout(freshCtx(), time.Second*5)
+	defer cancel()
+	r, err := wp.SubmitWaitXTCtx(ctx, Job{Name: "b", ConcurrencyKey: "b", Task: func(o Options) Result { return Result{Data: "ran"} }})
+	if err != nil || r.Data != "ran" {
+		t.Fatalf("Expected job with another key to run : got %v %v", r.Error, err)
+	}
+	if stats := wp.QueueStats()[""]; stats.Waiting != 5 {
+		t.Fatalf("Expected 5 jobs waiting for their key : got %d", stats.Waiting)
+	}
+
+	time.AfterFunc(time.Millisecond*10, func() { close(release) })
+	results := wp.StopXT()
+	if len(results) != 7 {
+		t.Fatalf("Expected a result for every job : got %d", len(results))
+	}
+	for _, r := range results {
+		if r.Name() == "a waiting" && r.Error != ErrJobNotRun {
+			t.Fatalf("Expected jobs waiting for their key to not run : got %v", r.Error)
+		}
+	}
+}
+
+func TestConcurrencyKeyDropOldest(t *testing.T) {
+	wp, release := fillQueue(t, 2, QueueDropOldest)
+	wp.SubmitXT(Job{Name: "queued", ConcurrencyKey: "x", Task: func(o Options) Result { return Result{} }})
+	wp.SubmitXT(Job{Name: "parked", ConcurrencyKey: "x", Task: func(o Options) Result { return Result{Data: "ran"} }})
+	wp.SubmitXT(Job{Name: "newest", Task: func(o Options) Result { return Result{Data: "ran"} }})
+	close(release)
+
+	results := wp.StopWaitXT()
+	if len(results) != 4 {
+		t.Fatalf("Expected 4 results : got %d", len(results))
+	}
+	for _, r := range results {
+		switch r.Name() {
+		case "queued":
+			if r.Error != ErrJobDropped {
+				t.Fatalf("Expected oldest job to be dropped : got %v", r.Error)
+			}
+		case "parked", "newest":
+			if r.Data != "ran" {
+				t.Fatalf("Expected '%s' to run : got %v", r.Name(), r.Error)
+			}
+		}
+	}
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var runs int32
+	job := Job{
+		Name:           "charge",
+		IdempotencyKey: "order-1",
+		Task: func(o Options) Result {
+			if atomic.AddInt32(&runs, 1) == 1 {
+				close(started)
+			}
+			<-release
+			return Result{Data: "charged"}
+		},
+	}
+
+	wp := makeDefaultWp()
+	first := wp.SubmitFutureXT(job)
+	<-started
+	second := wp.SubmitFutureXT(job)
+	if second != first {
+		t.Fatalf("Expected a running job's key to give back its Future")
+	}
+	wp.SubmitXT(job)
+	close(release)
+
+	r1, _ := first.Wait(freshCtx())
+	r2, _ := second.Wait(freshCtx())
+	if r1.Data != "charged" || r2.Data != "charged" {
+		t.Fatalf("Expected every caller to get the same result : got %v and %v", r1.Data, r2.Data)
+	}
+
+	// Without a window the key is forgotten once the job finished
+	wp.SubmitWaitXT(job)
+	if n := atomic.LoadInt32(&runs); n != 2 {
+		t.Fatalf("Expected job to run once while running and again once finished : got %d runs", n)
+	}
+	// Every submission has a Result, shared ones have the Result of the job they share
+	results := wp.StopWaitXT()
+	if len(results) != 4 {
+		t.Fatalf("Expected 1 result per submission : got %d", len(results))
+	}
+	for _, r := range results {
+		if r.Data != "charged" {
+			t.Fatalf("Expected every result to be the shared result : got %v", r.Data)
+		}
+	}
+}
+
+func TestIdempotencyKeyScheduledJobs(t *testing.T) {
+	clock := newFakeClock()
+	var runs int32
+	job := Job{
+		Name:           "charge",
+		IdempotencyKey: "order-1",
+		Task: func(o Options) Result {
+			return Result{Data: atomic.AddInt32(&runs, 1)}
+		},
+	}
+
+	wp := makeDefaultWp()
+	wp.SetClock(clock)
+	first := wp.SubmitAfter(time.Minute, job)
+	if wp.SubmitAfter(time.Minute, job) != first {
+		t.Fatalf("Expected a scheduled job's key to give back its Future")
+	}
+	if wp.SubmitFutureXT(job) != first {
+		t.Fatalf("Expected a scheduled job's key to give back its Future")
+	}
+	clock.waitForTimers(t, 1)
+	clock.Advance(time.Minute)
+	if r, _ := first.Wait(freshCtx()); r.Data != int32(1) {
+		t.Fatalf("Expected scheduled job to run : got %v", r.Data)
+	}
+
+	if n := len(wp.StopWaitXT()); n != 3 {
+		t.Fatalf("Expected 1 result per submission : got %d", n)
+	}
+	if n := atomic.LoadInt32(&runs); n != 1 {
+		t.Fatalf("Expected job to run once : got %d runs", n)
+	}
+}
+
+func TestDedupeWindow(t *testing.T) {
+	clock := newFakeClock()
+	var runs int32
+	job := Job{
+		Name:           "charge",
+		IdempotencyKey: "order-1",
+		Task: func(o Options) Result {
+			return Result{Data: atomic.AddInt32(&runs, 1)}
+		},
+	}
+
+	wp := makeDefaultWp()
+	wp.SetClock(clock)
+	wp.SetDedupeWindow(time.Minute)
+	wp.SubmitWaitXT(job)
+	clock.Advance(time.Second * 59)
+	if r := wp.SubmitWaitXT(job); r.Data != int32(1) {
+		t.Fatalf("Expected finished job to be remembered within the window : got run %v", r.Data)
+	}
+	clock.Advance(time.Second)
+	if r := wp.SubmitWaitXT(job); r.Data != int32(2) {
+		t.Fatalf("Expected job to run again once the window passed : got run %v", r.Data)
+	}
+	wp.StopWaitXT()
+}
+
+func TestIdempotencyKeyNotCancelledByOtherCaller(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	job := Job{
+		Name:           "shared",
+		IdempotencyKey: "shared",
+		Task: func(o Options) Result {
+			close(started)
+			<-release
+			return Result{Data: "ran"}
+		},
+	}
+
+	wp := makeDefaultWp()
+	f := wp.SubmitFutureXT(job)
+	<-started
+	ctx, cancel := context.WithTimeout(freshCtx(), time.Millisecond*10)
+	defer cancel()
+	if _, err := wp.SubmitWaitXTCtx(ctx, job); err != context.DeadlineExceeded {
+		t.Fatalf("Expected %s : got %v", context.DeadlineExceeded, err)
+	}
+	close(release)
+
+	if r, _ := f.Wait(freshCtx()); r.Data != "ran" {
+		t.Fatalf("Expected shared job to keep running : got %v", r.Error)
+	}
+	wp.StopWaitXT()
+}
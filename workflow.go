@@ -0,0 +1,207 @@
+package workerpoolxt
+
+import (
+	"context"
+	"fmt"
+)
+
+// Workflow is a set of steps that depend on each other. Each step runs once
+// every step it depends on has finished, and receives their results.
+type Workflow struct {
+	Steps       []Step
+	MaxParallel int           // MaxParallel caps how many steps run at once, 0 means no cap besides the pool itself
+	OnFailure   FailurePolicy // OnFailure decides what happens downstream of a failed step
+}
+
+// Step is a Job in a Workflow. Use TaskCtx and UpstreamResults to get
+// the results of the steps this one depends on.
+type Step struct {
+	ID        string
+	DependsOn []string
+	Job       Job
+}
+
+// FailurePolicy decides what happens downstream of a failed step
+type FailurePolicy int
+
+const (
+	// SkipDependents does not run anything downstream of a failed step, their
+	// Result.Error is ErrUpstreamFailed. Other branches keep running.
+	SkipDependents FailurePolicy = iota
+	// FailWorkflow cancels every running step and does not start any more,
+	// steps that never started have ErrWorkflowFailed as their Result.Error.
+	FailWorkflow
+	// RunDependents runs dependents anyway, they can check the upstream
+	// errors with UpstreamResults.
+	RunDependents
+)
+
+// upstreamKey is the context key for upstream results
+type upstreamKey struct{}
+
+// UpstreamResults returns the results of the steps the running step depends on,
+// keyed by step ID. Call it with the context passed to Job.TaskCtx.
+func UpstreamResults(ctx context.Context) map[string]Result {
+	rs, _ := ctx.Value(upstreamKey{}).(map[string]Result)
+	return rs
+}
+
+// Validate checks that step IDs are unique, every dependency exists and
+// there are no cycles
+func (w Workflow) Validate() error {
+	remaining := make(map[string]int, len(w.Steps))
+	dependents := make(map[string][]string, len(w.Steps))
+	for _, s := range w.Steps {
+		if _, ok := remaining[s.ID]; ok {
+			return fmt.Errorf("%w : '%s'", ErrDuplicateStep, s.ID)
+		}
+		remaining[s.ID] = len(s.DependsOn)
+	}
+
+	// Walk the graph from steps without dependencies, anything we can't reach is in a cycle
+	var order []string
+	for _, s := range w.Steps {
+		for _, dep := range s.DependsOn {
+			if _, ok := remaining[dep]; !ok {
+				return fmt.Errorf("%w : '%s' depends on '%s'", ErrUnknownDependency, s.ID, dep)
+			}
+			dependents[dep] = append(dependents[dep], s.ID)
+		}
+		if len(s.DependsOn) == 0 {
+			order = append(order, s.ID)
+		}
+	}
+	for i := 0; i < len(order); i++ {
+		for _, d := range dependents[order[i]] {
+			if remaining[d]--; remaining[d] == 0 {
+				order = append(order, d)
+			}
+		}
+	}
+
+	if len(order) < len(w.Steps) {
+		var cycle []string
+		for _, s := range w.Steps {
+			if remaining[s.ID] > 0 {
+				cycle = append(cycle, s.ID)
+			}
+		}
+		return fmt.Errorf("%w : between %v", ErrWorkflowCycle, cycle)
+	}
+	return nil
+}
+
+// RunWorkflow runs every step of the workflow on the pool, in dependency order,
+// and waits for them to finish. Results are keyed by step ID. Steps that ran are
+// also collected by the pool like any other job. An error is only returned,
+// before anything runs, if the workflow is not valid.
+//
+// Steps whose Job has no Context use ctx, and any step that has not started
+// when ctx is done will not run.
+func (p *WorkerPoolXT) RunWorkflow(ctx context.Context, w Workflow) (map[string]Result, error) {
+	if err := w.Validate(); err != nil {
+		return nil, err
+	}
+
+	steps := make(map[string]Step, len(w.Steps))
+	dependents := make(map[string][]string, len(w.Steps))
+	remaining := make(map[string]int, len(w.Steps))
+	var ready []string
+	for _, s := range w.Steps {
+		if s.Job.Name == "" {
+			s.Job.Name = s.ID
+		}
+		steps[s.ID] = s
+		remaining[s.ID] = len(s.DependsOn)
+		for _, dep := range s.DependsOn {
+			dependents[dep] = append(dependents[dep], s.ID)
+		}
+		if len(s.DependsOn) == 0 {
+			ready = append(ready, s.ID)
+		}
+	}
+
+	type finished struct {
+		id string
+		r  Result
+	}
+	done := make(chan finished)
+	running := make(map[string]*Future)
+	results := make(map[string]Result, len(steps))
+	failed := false
+
+	// resolve records a step's result and readies dependents whose dependencies are all done
+	resolve := func(id string, r Result) {
+		results[id] = r
+		for _, d := range dependents[id] {
+			if remaining[d]--; remaining[d] == 0 {
+				ready = append(ready, d)
+			}
+		}
+	}
+
+	for len(results) < len(steps) {
+		for len(ready) > 0 && (w.MaxParallel <= 0 || len(running) < w.MaxParallel) {
+			id := ready[0]
+			ready = ready[1:]
+			s := steps[id]
+
+			upstream := make(map[string]Result, len(s.DependsOn))
+			upstreamFailed := false
+			for _, dep := range s.DependsOn {
+				upstream[dep] = results[dep]
+				upstreamFailed = upstreamFailed || results[dep].Error != nil
+			}
+
+			switch {
+			case failed && w.OnFailure == FailWorkflow:
+				resolve(id, Result{Error: ErrWorkflowFailed, name: s.Job.Name})
+				continue
+			case upstreamFailed && w.OnFailure == SkipDependents:
+				resolve(id, Result{Error: ErrUpstreamFailed, name: s.Job.Name})
+				continue
+			case ctx.Err() != nil:
+				resolve(id, Result{Error: ctx.Err(), name: s.Job.Name})
+				continue
+			}
+
+			j := s.Job
+			if j.Context == nil {
+				j.Context = ctx
+			}
+			j.Context = context.WithValue(j.Context, upstreamKey{}, upstream)
+			f := p.SubmitFutureXT(j)
+			running[id] = f
+			go func(id string) {
+				r, _ := f.Wait(context.Background())
+				done <- finished{id: id, r: r}
+			}(id)
+		}
+
+		if len(running) == 0 {
+			continue
+		}
+
+		select {
+		case fin := <-done:
+			delete(running, fin.id)
+			if fin.r.Error != nil && !failed && w.OnFailure == FailWorkflow {
+				failed = true
+				for _, f := range running {
+					f.Cancel()
+				}
+			}
+			resolve(fin.id, fin.r)
+		case <-ctx.Done():
+			for _, f := range running {
+				f.Cancel()
+			}
+			// Running steps report back through done once cancelled
+			fin := <-done
+			delete(running, fin.id)
+			resolve(fin.id, fin.r)
+		}
+	}
+
+	return results, nil
+}